@@ -1,12 +1,25 @@
 package main
 
 import (
+	"archive/zip"
 	"fmt"
+	"github.com/TeslaMode1X/snippetbox/pkg/diff"
 	"github.com/TeslaMode1X/snippetbox/pkg/forms"
+	"github.com/TeslaMode1X/snippetbox/pkg/highlight"
+	"github.com/TeslaMode1X/snippetbox/pkg/markdown"
 	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"github.com/TeslaMode1X/snippetbox/pkg/totp"
 	"github.com/go-chi/chi/v5"
+	"html/template"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // User manipulations
@@ -35,7 +48,7 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
+	id, err := app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
 	if err == models.ErrDuplicateEmail {
 		form.Errors.Add("email", "Address is already in use")
 		app.render(w, r, "signup.page.tmpl", &templateData{Form: form})
@@ -45,7 +58,83 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.session.Put(r, "flash", "Your signup was successful. Please log in.")
+	// The account exists now whether or not the email goes out, and the
+	// user can ask for another link, so a failure is only logged.
+	err = app.sendVerification(&models.User{ID: id, Name: form.Get("name"), Email: form.Get("email")})
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+
+	app.session.Put(r, "flash", "Your signup was successful. Please follow the link we've emailed you to verify your address, then log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// verifyUser is an HTTP handler function for the link in verification
+// emails. It marks the user's email address as verified, so that they can
+// log in.
+func (app *application) verifyUser(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromToken(verifyPurpose, r.URL.Query().Get("token"))
+	if err == errInvalidToken {
+		app.session.Put(r, "flash", "That verification link is invalid or has expired. Please ask for a new one.")
+		http.Redirect(w, r, "/user/verify/resend", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !user.Verified {
+		err = app.users.Verify(user.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.session.Put(r, "flash", "Your email address has been verified. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) resendVerificationForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "resend.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// resendVerification is an HTTP handler function which emails a new
+// verification link. It responds the same way whether or not the address
+// belongs to an unverified user, so that it can't be used to find out which
+// addresses are registered.
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+
+	if !form.Valid() {
+		app.render(w, r, "resend.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	user, err := app.users.GetByEmail(form.Get("email"))
+	if err != nil && err != models.ErrNoRecord {
+		app.serverError(w, err)
+		return
+	}
+	if user != nil && !user.Verified {
+		err = app.sendVerification(user)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.session.Put(r, "flash", "If that address belongs to an account which isn't verified yet, we've emailed it a new link.")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
@@ -55,7 +144,899 @@ func (app *application) loginUserForm(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
+func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	email := form.Get("email")
+
+	// The failed attempts are checked before the password, so that guesses
+	// made while the account is locked or the client has to wait don't even
+	// get as far as bcrypt.
+	locked, wait, err := app.startLogin(r, email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if locked > 0 {
+		app.session.Put(r, "flash", lockedMessage(locked))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	if wait > 0 {
+		app.session.Put(r, "flash", waitMessage(wait))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	id, err := app.users.Authenticate(email, form.Get("password"))
+	notVerified := err == models.ErrNotVerified
+	if err == models.ErrInvalidCredentials {
+		if !app.failLogin(w, r, email) {
+			return
+		}
+		form.Errors.Add("generic", "Email or Password is incorrect")
+		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+		return
+	} else if err != nil && !notVerified {
+		app.serverError(w, err)
+		return
+	}
+
+	// The password was right, so the attempt wasn't a failure after all.
+	err = app.forgiveLogin(r, email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if notVerified {
+		form.Errors.Add("generic", "Please verify your email address before logging in")
+		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Users with two-factor authentication still have to give a code. Until
+	// they do, the session only holds a pending marker, and they aren't
+	// logged in.
+	if user.TOTPEnabled {
+		app.session.Put(r, "pendingUserID", user.ID)
+		app.session.Put(r, "pendingSince", int(time.Now().Unix()))
+		app.session.Remove(r, "pendingAttempts")
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	err = app.loginAttempts.Clear(accountKey(user.Email))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.logIn(r, user)
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// Two-factor authentication settings. Users who have given their password
+// have pendingLoginTTL to give their code, and maxCodeAttempts tries to get
+// it right.
+const (
+	totpIssuer        = "Snippetbox"
+	recoveryCodeCount = 10
+	pendingLoginTTL   = 5 * time.Minute
+	maxCodeAttempts   = 5
+)
+
+func (app *application) loginCodeForm(w http.ResponseWriter, r *http.Request) {
+	if app.pendingUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "code.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// loginCode is an HTTP handler function for the second step of logging in
+// with two-factor authentication. It takes a code from the user's
+// authenticator app, or one of their recovery codes, and logs them in once
+// it checks out. After maxCodeAttempts wrong codes the user has to start
+// again with their password.
+func (app *application) loginCode(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingUserID(r)
+	if id == 0 {
+		app.session.Put(r, "flash", "Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	if !form.Valid() {
+		app.render(w, r, "code.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Wrong codes count against the account just like wrong passwords do,
+	// so a locked account can't be logged in to with a code either.
+	locked, err := app.accountLocked(user.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if locked > 0 {
+		app.clearPendingLogin(r)
+		app.session.Put(r, "flash", lockedMessage(locked))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	ok, err := app.checkLoginCode(id, form.Get("code"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !ok {
+		err = app.loginFailed(r, user.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		attempts := app.session.GetInt(r, "pendingAttempts") + 1
+		if attempts >= maxCodeAttempts {
+			app.clearPendingLogin(r)
+			app.session.Put(r, "flash", "Too many wrong codes. Please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.session.Put(r, "pendingAttempts", attempts)
+
+		form.Errors.Add("code", "Code is incorrect")
+		app.render(w, r, "code.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	err = app.loginAttempts.Clear(accountKey(user.Email))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.clearPendingLogin(r)
+	app.logIn(r, user)
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// account is an HTTP handler function which shows the current user's details
+// along with the forms to change them.
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "account.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) updateName(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 255)
+
+	if !form.Valid() {
+		app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	err = app.users.UpdateName(app.authenticatedUser(r).ID, form.Get("name"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your name has been changed")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// updateEmail is an HTTP handler function which starts changing the current
+// user's email address. The user has to give their current password to do
+// so. The new address only replaces the current one once the link emailed to
+// it is followed, so that a typo can't lock the user out of their account.
+func (app *application) updateEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "email_password")
+	form.MatchesPattern("email", forms.EmailRX)
+
+	if !form.Valid() {
+		app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	ok, err := app.checkPassword(r, form, "email_password")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	} else if !ok {
+		app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	user := *app.authenticatedUser(r)
+	if form.Get("email") == user.Email {
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.SetPendingEmail(user.ID, form.Get("email"))
+	if err == models.ErrDuplicateEmail {
+		form.Errors.Add("email", "Address is already in use")
+		app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The user can send the form again if either email doesn't arrive, so
+	// failures are only logged.
+	user.PendingEmail = form.Get("email")
+	err = app.sendEmailChange(&user)
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+	err = app.sendEmailChangeNotice(&user)
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+
+	app.session.Put(r, "flash", "Please follow the link we've emailed to your new address to confirm it. Until then, your current address stays in use.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// confirmEmail is an HTTP handler function for the link emailed to a new
+// address. It replaces the user's email address with the new one.
+func (app *application) confirmEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromToken(changeEmailPurpose, r.URL.Query().Get("token"))
+	if err == errInvalidToken {
+		app.session.Put(r, "flash", "That confirmation link is invalid or has expired. Please change your email address again to get a new one.")
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.ConfirmEmail(user.ID, user.PendingEmail)
+	if err == models.ErrNoRecord {
+		app.session.Put(r, "flash", "That confirmation link is invalid or has expired. Please change your email address again to get a new one.")
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	} else if err == models.ErrDuplicateEmail {
+		app.session.Put(r, "flash", "That address has been taken by another account in the meantime.")
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your email address has been changed.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// changePassword is an HTTP handler function which changes the current
+// user's password. The user has to give their current password to do so.
+// Changing it logs out every other session the user has.
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("current_password", "new_password")
+	form.MinLength("new_password", 10)
+
+	if !form.Valid() {
+		app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	ok, err := app.checkPassword(r, form, "current_password")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	} else if !ok {
+		app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	err = app.users.ChangePassword(app.authenticatedUser(r).ID, form.Get("new_password"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Changing the password bumped the session version, so catch this
+	// session up with it to keep it logged in.
+	user, err := app.users.Get(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, "sessionVersion", user.SessionVersion)
+
+	app.session.Put(r, "flash", "Your password has been changed, and your other sessions have been logged out")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// twoFactor is an HTTP handler function which shows whether the current
+// user has two-factor authentication turned on. If they don't, it shows a QR
+// code for them to scan with their authenticator app.
+func (app *application) twoFactor(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactor(w, r, forms.New(nil))
+}
+
+// renderTwoFactor renders the two-factor page. Users who haven't turned it
+// on yet get a new secret, which is kept in their session until they
+// confirm it with a code.
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	user := app.authenticatedUser(r)
+	td := &templateData{Form: form}
+
+	if !user.TOTPEnabled {
+		otpURL := app.session.GetString(r, "totpURL")
+		if otpURL == "" {
+			key, err := totp.Generate(totpIssuer, user.Email)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			app.session.Put(r, "totpSecret", key.Secret)
+			app.session.Put(r, "totpURL", key.URL)
+			otpURL = key.URL
+		}
+
+		qr, err := totp.QRCode(otpURL, 200)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		td.TOTPSecret = app.session.GetString(r, "totpSecret")
+		// The QR code is a data: URI we made ourselves, which html/template
+		// would otherwise refuse to put in an src attribute.
+		td.QRCode = template.URL(qr)
+	}
+
+	app.render(w, r, "twofactor.page.tmpl", td)
+}
+
+// enableTwoFactor is an HTTP handler function which turns on two-factor
+// authentication once the user has confirmed their new secret with a code.
+// The user has to give their password as well, so that a stolen session
+// can't be used to tie the account to somebody else's authenticator. The
+// user's recovery codes are shown this once, and only their hashes are kept.
+func (app *application) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	secret := app.session.GetString(r, "totpSecret")
+	if app.authenticatedUser(r).TOTPEnabled || secret == "" {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "password")
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, form)
+		return
+	}
+	ok, err := app.checkPassword(r, form, "password")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	} else if !ok {
+		app.renderTwoFactor(w, r, form)
+		return
+	}
+
+	step, ok := totp.Validate(form.Get("code"), secret, time.Now())
+	if !ok {
+		form.Errors.Add("code", "Code is incorrect")
+		app.renderTwoFactor(w, r, form)
+		return
+	}
+
+	codes, err := totp.RecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	hashes := make([][]byte, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+
+	err = app.users.EnableTOTP(app.authenticatedUser(r).ID, secret, step, hashes)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Remove(r, "totpSecret")
+	app.session.Remove(r, "totpURL")
+	app.render(w, r, "recovery.page.tmpl", &templateData{RecoveryCodes: codes})
+}
+
+// disableTwoFactor is an HTTP handler function which turns off two-factor
+// authentication. The user has to give their password to do so.
+func (app *application) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !app.authenticatedUser(r).TOTPEnabled {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password")
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, form)
+		return
+	}
+	ok, err := app.checkPassword(r, form, "password")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	} else if !ok {
+		app.renderTwoFactor(w, r, form)
+		return
+	}
+
+	err = app.users.DisableTOTP(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Two-factor authentication has been turned off")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// resetTokenTTL is how long a password reset link stays valid.
+const resetTokenTTL = time.Hour
+
+func (app *application) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "forgot.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// forgotPassword is an HTTP handler function which emails a password reset
+// link. Like resendVerification, it responds the same way whether or not the
+// address is registered.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+
+	if !form.Valid() {
+		app.render(w, r, "forgot.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	user, err := app.users.GetByEmail(form.Get("email"))
+	if err != nil && err != models.ErrNoRecord {
+		app.serverError(w, err)
+		return
+	}
+	if user != nil {
+		err = app.sendPasswordReset(user)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.session.Put(r, "flash", "If that address belongs to an account, we've emailed it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	// The token is in the URL, so don't let it leak to other sites through
+	// the Referer header.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	app.render(w, r, "reset.page.tmpl", &templateData{
+		Form:  forms.New(nil),
+		Token: chi.URLParam(r, "token"),
+	})
+}
+
+// resetPassword is an HTTP handler function which sets a new password using
+// the token from a reset link. Every session the user had is logged out.
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	token := chi.URLParam(r, "token")
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	form.MinLength("password", 10)
+
+	if !form.Valid() {
+		app.render(w, r, "reset.page.tmpl", &templateData{Form: form, Token: token})
+		return
+	}
+
+	id, err := app.users.ResetPassword(token, form.Get("password"))
+	if err == models.ErrInvalidToken {
+		form.Errors.Add("generic", "This reset link is invalid, has expired or has already been used")
+		app.render(w, r, "reset.page.tmpl", &templateData{Form: form, Token: token})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The failed logins were guesses at the old password, so they shouldn't
+	// keep the account locked now that it has a new one.
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.loginAttempts.Clear(accountKey(user.Email))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Log this session out too, in case it belonged to somebody else.
+	app.session.Remove(r, "userID")
+	app.session.Remove(r, "sessionVersion")
+	app.session.Put(r, "flash", "Your password has been reset. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
+	// Remove the userID from the session data so that the user is 'logged out'
+	app.session.Remove(r, "userID")
+	// Add a flash message to the session to confirm to the user that they've been removed
+	app.session.Put(r, "flash", "You've been logged out successfully!")
+	http.Redirect(w, r, "/", 303)
+}
+
+// home is an HTTP handler function for the root URL path ("/"). It shows the
+// first page of snippets, and links on to the archive for the rest.
+func (app *application) home(w http.ResponseWriter, r *http.Request) {
+	page, err := app.snippets.Page(models.PageRequest{
+		Limit:       app.pageSize,
+		NewestFirst: app.newestFirst,
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	starred, err := app.stars.MostStarred(time.Now().AddDate(0, 0, -7), 5)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "home.page.tmpl", &templateData{
+		Snippets:    page.Snippets,
+		MostStarred: starred,
+		Pagination:  newPagination("/archive", page),
+	})
+}
+
+// archive is an HTTP handler function which lists every public snippet, one
+// page at a time.
+func (app *application) archive(w http.ResponseWriter, r *http.Request) {
+	req, err := app.pageRequest(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.snippets.Page(req)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "archive.page.tmpl", &templateData{
+		Snippets:   page.Snippets,
+		Pagination: newPagination("/archive", page),
+	})
+}
+
+// showTag is an HTTP handler function which lists the public snippets with
+// the tag in the URL, one page at a time.
+func (app *application) showTag(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(chi.URLParam(r, "name"))
+	if !forms.TagRX.MatchString(tag) {
+		app.notFound(w)
+		return
+	}
+
+	req, err := app.pageRequest(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	req.Tag = tag
+
+	page, err := app.snippets.Page(req)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "tag.page.tmpl", &templateData{
+		Tag:        tag,
+		Snippets:   page.Snippets,
+		Pagination: newPagination("/tag/"+url.PathEscape(tag), page),
+	})
+}
+
+// search is an HTTP handler function which finds the public snippets
+// matching the 'q' query string parameter. Without a query it just shows the
+// search form.
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	form.MaxLength("q", 100)
+
+	query := strings.TrimSpace(form.Get("q"))
+	if query == "" || !form.Valid() {
+		app.render(w, r, "search.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	snippets, err := app.snippets.Search(query, app.pageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "search.page.tmpl", &templateData{
+		Form:     form,
+		Query:    query,
+		Snippets: snippets,
+	})
+}
+
+// showSnippet is an HTTP handler function for displaying a specific snippet.
+func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	// Protected snippets ask for their password before anything else.
+	if app.isLocked(r, s) {
+		app.render(w, r, "unlock.page.tmpl", &templateData{
+			Form:    forms.New(nil),
+			Snippet: s,
+		})
+		return
+	}
+
+	// Burn-after-reading snippets are only revealed once the reader has
+	// confirmed that they want to see (and so destroy) them. Their owner
+	// can look at them as often as they like.
+	if s.BurnAfterReading && !app.isOwner(r, s) {
+		app.render(w, r, "burn.page.tmpl", &templateData{
+			Snippet: s,
+		})
+		return
+	}
+
+	// Owners looking at their own snippets aren't counted as readers.
+	if !app.isOwner(r, s) {
+		app.countView(r, s.ID)
+	}
+
+	app.renderSnippet(w, r, s, forms.New(nil))
+}
+
+// renderSnippet shows a snippet which the current user is allowed to read,
+// along with its parent, forks and comments. The form is the comment form,
+// which holds errors if a comment couldn't be posted.
+func (app *application) renderSnippet(w http.ResponseWriter, r *http.Request, s *models.Snippet, form *forms.Form) {
+	td := &templateData{Snippet: s, Form: form}
+
+	// Link back to the snippet this one was forked from, as long as it's
+	// still around and the reader is allowed to see it.
+	if s.ForkedFrom != 0 {
+		parent, err := app.snippets.Get(s.ForkedFrom)
+		if err != nil && err != models.ErrNoRecord {
+			app.serverError(w, err)
+			return
+		}
+		if parent != nil && app.canView(r, parent) {
+			td.Parent = parent
+		}
+	}
+
+	var err error
+	td.ForkCount, err = app.snippets.ForkCount(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if td.ForkCount > 0 {
+		td.Forks, err = app.snippets.Forks(s.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	td.Comments, err = app.comments.ForSnippet(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td.Stars, err = app.stars.Count(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if user := app.authenticatedUser(r); user != nil {
+		td.Starred, err = app.stars.Starred(s.ID, user.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.render(w, r, "show.page.tmpl", td)
+}
+
+// rawSnippet is an HTTP handler function which sends the content of a
+// snippet as plain text, for use with tools like curl.
+func (app *application) rawSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.rawSnippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	// nosniff stops browsers from treating content which looks like HTML as
+	// a web page.
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write([]byte(s.Content))
+}
+
+// downloadSnippet works like rawSnippet, but asks the browser to save the
+// content as a file named after the snippet.
+func (app *application) downloadSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.rawSnippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": downloadName(s)})
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write([]byte(s.Content))
+}
+
+// archiveSnippet is an HTTP handler function which sends all of the files of
+// a snippet as a zip archive. The archive is streamed straight to the client
+// rather than being built in memory first.
+func (app *application) archiveSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.rawSnippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	files := append([]*models.File{{Name: downloadName(s), Content: s.Content}}, s.Files...)
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": titleName(s) + ".zip"})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", disposition)
+
+	// Once the first byte has been written the response status can't be
+	// changed any more, so errors from here on can only be logged.
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.Name,
+			Method:   zip.Deflate,
+			Modified: s.Created,
+		})
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+
+		_, err = io.WriteString(fw, f.Content)
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// addComment is an HTTP handler function which posts a comment on a
+// snippet, optionally about one line of its main file.
+func (app *application) addComment(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+	// Only people who can read the snippet get to comment on it.
+	if !app.canRead(r, s) {
+		http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
@@ -63,52 +1044,163 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	form := forms.New(r.PostForm)
-	id, err := app.users.Authenticate(form.Get("email"), form.Get("password"))
-	if err == models.ErrInvalidCredentials {
-		form.Errors.Add("generic", "Email or Password is incorrect")
-		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+	form.Required("comment")
+	form.MaxLength("comment", 2000)
+
+	// Lines are only numbered for content shown as code, so a rendered
+	// Markdown snippet has nothing to anchor a comment to.
+	line := 0
+	if v := form.Get("line"); v != "" && s.Format != models.FormatMarkdown {
+		lines := strings.Count(strings.TrimSuffix(s.Content, "\n"), "\n") + 1
+		line, err = strconv.Atoi(v)
+		if err != nil || line < 1 || line > lines {
+			form.Errors.Add("line", fmt.Sprintf("Pick a line between 1 and %d", lines))
+		}
+	}
+
+	if !form.Valid() {
+		app.renderSnippet(w, r, s, form)
+		return
+	}
+
+	id, err := app.comments.Insert(s.ID, app.authenticatedUser(r).ID, line, form.Get("comment"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Comment posted!")
+	http.Redirect(w, r, fmt.Sprintf("%s#comment-%d", snippetURL(s), id), http.StatusSeeOther)
+}
+
+// deleteComment is an HTTP handler function which deletes a comment. Only
+// the author of a comment may delete it.
+func (app *application) deleteComment(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	c, err := app.comments.Get(id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
 		return
 	} else if err != nil {
 		app.serverError(w, err)
 		return
 	}
+	// The comment has to belong to the snippet in the URL.
+	if c.SnippetID != s.ID {
+		app.notFound(w)
+		return
+	}
+	if c.UserID != app.authenticatedUser(r).ID {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.comments.Delete(c.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-	app.session.Put(r, "userID", id)
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	app.session.Put(r, "flash", "Comment deleted")
+	http.Redirect(w, r, snippetURL(s)+"#comments", http.StatusSeeOther)
 }
 
-func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
-	// Remove the userID from the session data so that the user is 'logged out'
-	app.session.Remove(r, "userID")
-	// Add a flash message to the session to confirm to the user that they've been removed
-	app.session.Put(r, "flash", "You've been logged out successfully!")
-	http.Redirect(w, r, "/", 303)
+// toggleStar is an HTTP handler function which stars a snippet for the
+// current user, or takes their star away if they had already starred it.
+func (app *application) toggleStar(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !app.canRead(r, s) {
+		http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
+		return
+	}
+
+	starred, err := app.stars.Toggle(s.ID, app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if starred {
+		app.session.Put(r, "flash", "Snippet starred!")
+	} else {
+		app.session.Put(r, "flash", "Star removed")
+	}
+	http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
 }
 
-// home is an HTTP handler function for the root URL path ("/").
-func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	s, err := app.snippets.Latest()
+// userStars is an HTTP handler function which lists the live snippets the
+// current user starred.
+func (app *application) userStars(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.stars.ForUser(app.authenticatedUser(r).ID)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	app.render(w, r, "home.page.tmpl", &templateData{
-		Snippets: s,
-	})
+	app.render(w, r, "stars.page.tmpl", &templateData{Snippets: snippets})
 }
 
-// showSnippet is an HTTP handler function for displaying a specific snippet.
-func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
+// statsDays is the number of days showSnippetStats reports on.
+const statsDays = 30
+
+// showSnippetStats is an HTTP handler function which shows the owner of a
+// snippet how often it was viewed on each of the last statsDays days.
+func (app *application) showSnippetStats(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, 1-statsDays)
+
+	counted, err := app.views.Daily(s.ID, first)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Fill in the days without any views, newest first.
+	views := map[time.Time]int{}
+	for _, d := range counted {
+		views[d.Day.UTC().Truncate(24*time.Hour)] = d.Views
+	}
+	td := &templateData{Snippet: s}
+	for day := today; !day.Before(first); day = day.AddDate(0, 0, -1) {
+		n := views[day]
+		td.Views = append(td.Views, &models.DailyViews{Day: day, Views: n})
+		td.TotalViews += n
+		td.MaxViews = max(td.MaxViews, n)
+	}
+
+	app.render(w, r, "stats.page.tmpl", td)
+}
+
+// redirectSnippet is an HTTP handler function which sends links from before
+// snippets had slugs, of the form /snippet/{id}, on to the snippet's current
+// address. Sequential IDs are easy to guess, so this is only done for public
+// snippets (and for the owner); for anything else a 404 is returned, as
+// otherwise walking through the IDs would reveal unlisted snippets.
+func (app *application) redirectSnippet(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil || id < 1 {
 		app.notFound(w)
 		return
 	}
 
-	// Use the SnippetModel object's Get method to retrieve the data for a
-	// specific record based on its ID. If no matching record is found,
-	// return a 404 Not Found response.
 	s, err := app.snippets.Get(id)
 	if err == models.ErrNoRecord {
 		app.notFound(w)
@@ -118,15 +1210,126 @@ func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.Visibility != models.VisibilityPublic && !app.isOwner(r, s) {
+		app.notFound(w)
+		return
+	}
+
+	http.Redirect(w, r, snippetURL(s), http.StatusMovedPermanently)
+}
+
+// burnSnippet is an HTTP handler function which reveals a burn-after-reading
+// snippet to its first reader and deletes it at the same time.
+func (app *application) burnSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.BurnAfterReading || app.isOwner(r, s) || app.isLocked(r, s) {
+		http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
+		return
+	}
+
+	// Somebody else may have burned the snippet since we fetched it, in
+	// which case Burn() reports that there is no record.
+	s, err := app.snippets.Burn(s.ID)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	app.render(w, r, "show.page.tmpl", &templateData{
 		Snippet: s,
+		Burned:  true,
 	})
 }
 
+// unlockSnippet is an HTTP handler function which checks the password of a
+// protected snippet and, if it is right, remembers in the session that the
+// snippet has been unlocked.
+func (app *application) unlockSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	// Refuse to even check the password once too many wrong ones have been
+	// tried for this snippet, to make guessing it impractical.
+	if !app.unlockLimiter.Allow(s.ID) {
+		form.Errors.Add("generic", "Too many failed attempts. Please try again later.")
+		app.render(w, r, "unlock.page.tmpl", &templateData{Form: form, Snippet: s})
+		return
+	}
+
+	err = app.snippets.Unlock(s.ID, form.Get("password"))
+	if err == models.ErrInvalidCredentials {
+		app.unlockLimiter.Fail(s.ID)
+		form.Errors.Add("generic", "Password is incorrect")
+		app.render(w, r, "unlock.page.tmpl", &templateData{Form: form, Snippet: s})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, unlockedKey(s.ID), true)
+	http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
+}
+
 func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "create.page.tmpl", &templateData{
-		Form: forms.New(nil),
+	// Pick the longest of the expiry options by default.
+	var longest time.Duration
+	for _, d := range app.expiryOptions {
+		longest = max(longest, d)
+	}
+
+	// Guess the language of the content unless the author picks one.
+	form := forms.New(url.Values{
+		"expires":  []string{longest.String()},
+		"format":   []string{models.FormatText},
+		"language": []string{"auto"},
 	})
+	td := &templateData{
+		Form:          form,
+		ExpiryOptions: app.expiryOptions,
+		Languages:     highlight.Languages,
+	}
+
+	// When forking, start from a copy of the snippet being forked.
+	if slug := r.URL.Query().Get("fork"); slug != "" {
+		parent, err := app.forkSource(r, slug)
+		if err == models.ErrNoRecord {
+			app.notFound(w)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		form.Set("forked_from", parent.Slug)
+		form.Set("title", parent.Title)
+		form.Set("filename", parent.Filename)
+		form.Set("content", parent.Content)
+		form.Set("format", parent.Format)
+		form.Set("language", parent.Language)
+		form.Set("tags", strings.Join(parent.Tags, ", "))
+		td.Files = parent.Files
+		td.Parent = parent
+	}
+
+	app.render(w, r, "create.page.tmpl", td)
 }
 
 // createSnippet is an HTTP handler function for creating a new snippet.
@@ -138,16 +1341,78 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	form := forms.New(r.PostForm)
-	form.Required("title", "content", "expires")
-	form.MaxLength("title", 100)
-	form.PermittedValues("expires", "365", "7", "1")
+	validateSnippetForm(form)
+	form.PermittedValues("burn", "true")
+	form.ValidTags("tags", 5, 30)
+	form.PermittedValues("format", models.FormatText, models.FormatMarkdown)
+	validateLanguage(form)
+	files := filesFromForm(form)
+	expires := app.validateExpiry(form)
+
+	// A fork records the snippet it was copied from, which has to be one
+	// the author is allowed to fork.
+	var parent *models.Snippet
+	if slug := form.Get("forked_from"); slug != "" {
+		parent, err = app.forkSource(r, slug)
+		if err == models.ErrNoRecord {
+			form.Errors.Add("forked_from", "The snippet you are forking is no longer available")
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
 
 	if !form.Valid() {
-		app.render(w, r, "create.page.tmpl", &templateData{Form: form})
+		app.render(w, r, "create.page.tmpl", &templateData{
+			Form:          form,
+			Files:         files,
+			Parent:        parent,
+			ExpiryOptions: app.expiryOptions,
+			Languages:     highlight.Languages,
+		})
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Get("title"), form.Get("content"), form.Get("expires"))
+	// Content is text unless the author asks for Markdown. Markdown is
+	// rendered rather than highlighted, so it has no language.
+	format := form.Get("format")
+	if format == "" {
+		format = models.FormatText
+	}
+
+	language := form.Get("language")
+	if format == models.FormatMarkdown {
+		language = ""
+	} else if language == "auto" {
+		language = highlight.Detect(form.Get("content"))
+	}
+
+	for _, f := range files {
+		if f.Language == "auto" {
+			f.Language = highlight.Detect(f.Content)
+		}
+	}
+
+	s := &models.Snippet{
+		// Record the currently logged in user as the owner of the snippet.
+		// The requireAuthentication middleware guarantees that there is one.
+		UserID:           app.authenticatedUser(r).ID,
+		Title:            form.Get("title"),
+		Filename:         form.Get("filename"),
+		Content:          form.Get("content"),
+		Format:           format,
+		Language:         language,
+		Files:            files,
+		Visibility:       form.Get("visibility"),
+		BurnAfterReading: form.Get("burn") == "true",
+		Tags:             forms.Tags(form.Get("tags")),
+		Expires:          expires,
+	}
+	if parent != nil {
+		s.ForkedFrom = parent.ID
+	}
+
+	slug, err := app.snippets.Insert(s, form.Get("password"))
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -160,7 +1425,289 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	// will automatically be created by the session middleware.
 	app.session.Put(r, "flash", "Snippet successfully created!")
 	// Redirect the user to the relevant page for the snippet.
-	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
+	http.Redirect(w, r, "/s/"+slug, http.StatusSeeOther)
+}
+
+// previewMarkdown renders the 'content' field of the create form as
+// Markdown and sends back the sanitized HTML, for the form to show before the
+// snippet is published.
+func (app *application) previewMarkdown(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	html, err := markdown.HTML(r.PostForm.Get("content"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
+
+func (app *application) editSnippetForm(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	// Pre-fill the form with the current values of the snippet.
+	app.render(w, r, "edit.page.tmpl", &templateData{
+		Form: forms.New(url.Values{
+			"title":      []string{s.Title},
+			"content":    []string{s.Content},
+			"visibility": []string{s.Visibility},
+		}),
+		Snippet: s,
+	})
+}
+
+// editSnippet is an HTTP handler function for saving changes to a snippet.
+func (app *application) editSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validateSnippetForm(form)
+
+	if !form.Valid() {
+		app.render(w, r, "edit.page.tmpl", &templateData{Form: form, Snippet: s})
+		return
+	}
+
+	err = app.snippets.Update(s.ID, form.Get("title"), form.Get("content"), form.Get("visibility"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Snippet successfully updated!")
+	http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
+}
+
+// deleteSnippet is an HTTP handler function for deleting a snippet.
+func (app *application) deleteSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Snippet successfully deleted!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// showRevisions is an HTTP handler function for listing the saved versions of
+// a snippet.
+func (app *application) showRevisions(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.historyFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := app.snippets.Revisions(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "revisions.page.tmpl", &templateData{
+		Snippet:   s,
+		Revisions: revisions,
+	})
+}
+
+// showDiff is an HTTP handler function for displaying the changes between two
+// revisions of a snippet, given by the 'from' and 'to' query parameters.
+func (app *application) showDiff(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.historyFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	revisions := make([]*models.Revision, 2)
+	for i, number := range []int{from, to} {
+		revisions[i], err = app.snippets.Revision(s.ID, number)
+		if err == models.ErrNoRecord {
+			app.notFound(w)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	td := &templateData{
+		Snippet: s,
+		From:    revisions[0],
+		To:      revisions[1],
+	}
+	// The time a diff takes grows with the number of lines times the number
+	// of differences, so very long revisions aren't compared at all.
+	if lineCount(revisions[0].Content) > maxDiffLines || lineCount(revisions[1].Content) > maxDiffLines {
+		td.DiffTooLarge = true
+	} else {
+		td.Diff = diff.Unified(revisions[0].Content, revisions[1].Content, 3)
+	}
+
+	app.render(w, r, "diff.page.tmpl", td)
+}
+
+// maxDiffLines is the most lines either revision can have for showDiff to
+// compare them.
+const maxDiffLines = 3000
+
+func lineCount(s string) int {
+	return strings.Count(s, "\n") + 1
+}
+
+// validateExpiry checks the 'expires' field of the create form and returns the
+// time at which the new snippet should expire. The field holds one of the
+// configured expiry durations, "never", or "custom", in which case the exact
+// time is taken from the 'expires_at' field.
+func (app *application) validateExpiry(form *forms.Form) time.Time {
+	permitted := []string{"never", "custom"}
+	for _, d := range app.expiryOptions {
+		permitted = append(permitted, d.String())
+	}
+	form.Required("expires")
+	form.PermittedValues("expires", permitted...)
+
+	switch form.Get("expires") {
+	case "never":
+		return models.Never
+	case "custom":
+		form.Required("expires_at")
+		if form.Get("expires_at") == "" {
+			return time.Time{}
+		}
+		// The browser sends the value of a datetime-local input without
+		// a time zone. The form asks for UTC, so that's how we read it.
+		t, err := time.Parse("2006-01-02T15:04", form.Get("expires_at"))
+		if err != nil {
+			form.Errors.Add("expires_at", "This field is invalid")
+		} else if !t.After(time.Now()) {
+			form.Errors.Add("expires_at", "This time is in the past")
+		} else if t.After(models.Never) {
+			form.Errors.Add("expires_at", "This time is too far in the future")
+		}
+		return t
+	default:
+		for _, d := range app.expiryOptions {
+			if form.Get("expires") == d.String() {
+				return time.Now().Add(d)
+			}
+		}
+		return time.Time{}
+	}
+}
+
+// languageValues lists the values a language field can take, other than
+// empty for plain text: "auto" and the languages snippets can be highlighted
+// as.
+func languageValues() []string {
+	values := []string{"auto"}
+	for _, l := range highlight.Languages {
+		values = append(values, l.ID)
+	}
+	return values
+}
+
+// validateLanguage checks that the 'language' field is either empty or one
+// of languageValues.
+func validateLanguage(form *forms.Form) {
+	form.PermittedValues("language", languageValues()...)
+}
+
+// maxFiles is the most files a snippet can hold, counting its main file.
+const maxFiles = 10
+
+// filesFromForm collects the further files of a snippet from the create
+// form, where each one is a file_name, file_language and file_content field,
+// and checks them. Problems with them are reported on the 'files' field, and
+// with the name of the main file on the 'filename' field.
+func filesFromForm(form *forms.Form) []*models.File {
+	names, languages, contents := form.Values["file_name"], form.Values["file_language"], form.Values["file_content"]
+	if len(names) != len(contents) || len(languages) != len(contents) {
+		form.Errors.Add("files", "This field is invalid")
+		return nil
+	}
+
+	files := make([]*models.File, len(names))
+	for i := range names {
+		files[i] = &models.File{
+			Name:     strings.TrimSpace(names[i]),
+			Language: languages[i],
+			Content:  contents[i],
+		}
+	}
+
+	form.MaxLength("filename", 100)
+	form.MatchesPattern("filename", forms.FilenameRX)
+	if len(files) == 0 {
+		return files
+	}
+
+	if len(files)+1 > maxFiles {
+		form.Errors.Add("files", fmt.Sprintf("Too many files (maximum is %d)", maxFiles))
+		return files
+	}
+	if form.Get("filename") == "" {
+		form.Errors.Add("filename", "Name the main file when sharing several files")
+	}
+
+	seen := map[string]bool{form.Get("filename"): true}
+	for _, f := range files {
+		switch {
+		case f.Name == "":
+			form.Errors.Add("files", "Every file needs a name")
+		case utf8.RuneCountInString(f.Name) > 100 || !forms.FilenameRX.MatchString(f.Name):
+			form.Errors.Add("files", fmt.Sprintf("The name %q is invalid", f.Name))
+		case seen[f.Name]:
+			form.Errors.Add("files", fmt.Sprintf("There is more than one file named %q", f.Name))
+		case strings.TrimSpace(f.Content) == "":
+			form.Errors.Add("files", fmt.Sprintf("The file %q is empty", f.Name))
+		case f.Language != "" && !slices.Contains(languageValues(), f.Language):
+			form.Errors.Add("files", fmt.Sprintf("The language of %q is invalid", f.Name))
+		}
+		seen[f.Name] = true
+	}
+
+	return files
+}
+
+// validateSnippetForm runs the checks shared by the create and edit forms.
+func validateSnippetForm(form *forms.Form) {
+	form.Required("title", "content", "visibility")
+	form.MaxLength("title", 100)
+	form.PermittedValues("visibility", models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate)
 }
 
 func ping(w http.ResponseWriter, r *http.Request) {
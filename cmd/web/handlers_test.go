@@ -1,10 +1,20 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"github.com/TeslaMode1X/snippetbox/pkg/mailer"
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"github.com/TeslaMode1X/snippetbox/pkg/models/mock"
+	"github.com/pquerna/otp/totp"
+	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestShowSnippet(t *testing.T) {
@@ -24,13 +34,21 @@ func TestShowSnippet(t *testing.T) {
 		wantCode int
 		wantBody []byte
 	}{
-		{"Valid ID", "/snippet/1", http.StatusOK, []byte("An old silent pond...")},
+		{"Valid slug", "/s/pond", http.StatusOK, []byte("An old silent pond...")},
+		{"Unlisted", "/s/dew", http.StatusOK, []byte("A world of dew")},
+		{"Private", "/s/autumn", http.StatusNotFound, nil},
+		{"Non-existent slug", "/s/missing", http.StatusNotFound, nil},
+		{"Empty slug", "/s/", http.StatusNotFound, nil},
+		{"Trailing slash", "/s/pond/", http.StatusNotFound, nil},
+		{"Old link", "/snippet/1", http.StatusMovedPermanently, []byte("/s/pond")},
+		{"Old link to unlisted snippet", "/snippet/5", http.StatusNotFound, nil},
+		{"Old link to private snippet", "/snippet/4", http.StatusNotFound, nil},
 		{"Non-existent ID", "/snippet/2", http.StatusNotFound, nil},
 		{"Negative ID", "/snippet/-1", http.StatusNotFound, nil},
 		{"Decimal ID", "/snippet/1.23", http.StatusNotFound, nil},
 		{"String ID", "/snippet/foo", http.StatusNotFound, nil},
 		{"Empty ID", "/snippet/", http.StatusNotFound, nil},
-		{"Trailing slash", "/snippet/1/", http.StatusNotFound, nil},
+		{"Trailing slash on old link", "/snippet/1/", http.StatusNotFound, nil},
 	}
 
 	for _, tt := range tests {
@@ -46,6 +64,225 @@ func TestShowSnippet(t *testing.T) {
 	}
 }
 
+func TestShowPrivateSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The owner's private snippet is hidden until they log in.
+	code, _, _ := ts.get(t, "/s/moon")
+	if code != http.StatusNotFound {
+		t.Errorf("want %d; got %d", http.StatusNotFound, code)
+	}
+
+	ts.login(t)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{"Own private snippet", "/s/moon", http.StatusOK},
+		{"Own private revisions", "/s/moon/revisions", http.StatusOK},
+		{"Private snippet of another user", "/s/autumn", http.StatusNotFound},
+		{"Private revisions of another user", "/s/autumn/revisions", http.StatusNotFound},
+		{"Editing private snippet of another user", "/s/autumn/edit", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestBurnSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The first visit only shows a warning, not the content.
+	code, _, body := ts.get(t, "/s/password")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if bytes.Contains(body, []byte("correct horse battery staple")) {
+		t.Errorf("want warning page not to contain the content")
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	// The history would give the content away too.
+	code, _, _ = ts.get(t, "/s/password/revisions")
+	if code != http.StatusNotFound {
+		t.Errorf("want %d; got %d", http.StatusNotFound, code)
+	}
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Burn", "/s/password/burn", http.StatusOK, "", []byte("correct horse battery staple")},
+		{"Not a burn-after-reading snippet", "/s/pond/burn", http.StatusSeeOther, "/s/pond", nil},
+		{"Non-existent ID", "/s/missing/burn", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUnlockSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Until it is unlocked, the snippet only shows a password prompt.
+	code, _, body := ts.get(t, "/s/keys")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if bytes.Contains(body, []byte("ssh-ed25519")) {
+		t.Errorf("want password prompt not to contain the content")
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	code, headers, _ := ts.get(t, "/s/keys/revisions")
+	if code != http.StatusSeeOther || headers.Get("Location") != "/s/keys" {
+		t.Errorf("want redirect to /s/keys; got %d %q", code, headers.Get("Location"))
+	}
+
+	unlock := func(password string) (int, []byte) {
+		form := url.Values{}
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+		code, _, body := ts.postForm(t, "/s/keys/unlock", form)
+		return code, body
+	}
+
+	code, body = unlock("wrong")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("Password is incorrect")) {
+		t.Errorf("want wrong password to be rejected; got %d", code)
+	}
+
+	code, _ = unlock("open sesame")
+	if code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	// The session now remembers that the snippet was unlocked.
+	_, _, body = ts.get(t, "/s/keys")
+	if !bytes.Contains(body, []byte("ssh-ed25519")) {
+		t.Errorf("want unlocked snippet to show its content")
+	}
+
+	// Public snippets can be protected too.
+	form := url.Values{}
+	form.Add("password", "open sesame")
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/s/vault/unlock", form)
+	_, _, body = ts.get(t, "/s/vault")
+	if !bytes.Contains(body, []byte("hvs.vaultsecret")) {
+		t.Errorf("want the unlocked public snippet to show its content")
+	}
+}
+
+func TestUnlockSnippetRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/s/keys")
+	csrfToken := extractCSRFToken(t, body)
+
+	for _, password := range []string{"one", "two", "three", "four", "five", "open sesame"} {
+		form := url.Values{}
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+		_, _, body = ts.postForm(t, "/s/keys/unlock", form)
+	}
+
+	// Even the right password is refused once too many wrong ones were tried.
+	if !bytes.Contains(body, []byte("Too many failed attempts")) {
+		t.Errorf("want the sixth attempt to be rate limited")
+	}
+}
+
+func TestCreateSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	code, _, body := ts.get(t, "/snippet/create")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	// The longest of the configured expiry options is picked by default.
+	if !bytes.Contains(body, []byte("value='8760h0m0s' checked")) {
+		t.Errorf("want the longest expiry option to be checked")
+	}
+
+	future := time.Now().UTC().Add(48 * time.Hour).Format("2006-01-02T15:04")
+	past := time.Now().UTC().Add(-48 * time.Hour).Format("2006-01-02T15:04")
+
+	tests := []struct {
+		name      string
+		expires   string
+		expiresAt string
+		wantCode  int
+		wantBody  []byte
+	}{
+		{"Configured duration", "1h0m0s", "", http.StatusSeeOther, nil},
+		{"Never", "never", "", http.StatusSeeOther, nil},
+		{"Custom time", "custom", future, http.StatusSeeOther, nil},
+		{"Duration which isn't configured", "7h0m0s", "", http.StatusOK, []byte("This field is invalid")},
+		{"Custom time in the past", "custom", past, http.StatusOK, []byte("This time is in the past")},
+		{"Custom time missing", "custom", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Custom time malformed", "custom", "tomorrow", http.StatusOK, []byte("This field is invalid")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "A title")
+			form.Add("content", "Some content")
+			form.Add("visibility", "public")
+			form.Add("expires", tt.expires)
+			form.Add("expires_at", tt.expiresAt)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
 func TestSignupUser(t *testing.T) {
 	// Create a new instance of our application struct which uses mocked dependencies.
 	app := newTestApplication(t)
@@ -57,7 +294,9 @@ func TestSignupUser(t *testing.T) {
 	_, _, body := ts.get(t, "/user/signup")
 	csrfToken := extractCSRFToken(t, body)
 
-	// Set up table-driven tests for user signup.
+	// Set up table-driven tests for user signup. A valid signup redirects to
+	// the login page, which flashes wantBody; invalid ones show the form
+	// again with the errors in it.
 	tests := []struct {
 		name         string
 		userName     string
@@ -67,16 +306,16 @@ func TestSignupUser(t *testing.T) {
 		wantCode     int
 		wantBody     []byte
 	}{
-		{"Valid submission", "Bob", "bob@example.com", "validPa$$word", csrfToken, http.StatusOK, []byte("Signup successful")},
-		{"Empty name", "", "bob@example.com", "validPa$$word", csrfToken, http.StatusBadRequest, []byte("Name is required")},
-		{"Empty email", "Bob", "", "validPa$$word", csrfToken, http.StatusBadRequest, []byte("Email is required")},
-		{"Empty password", "Bob", "bob@example.com", "", csrfToken, http.StatusBadRequest, []byte("Password is required")},
-		{"Invalid email (incomplete domain)", "Bob", "bob@example.", "validPa$$word", csrfToken, http.StatusBadRequest, []byte("Email is invalid")},
-		{"Invalid email (missing @)", "Bob", "bobexample.com", "validPa$$word", csrfToken, http.StatusBadRequest, []byte("Email is invalid")},
-		{"Invalid email (missing local part)", "Bob", "@example.com", "validPa$$word", csrfToken, http.StatusBadRequest, []byte("Email is invalid")},
-		{"Short password", "Bob", "bob@example.com", "pa$$word", csrfToken, http.StatusBadRequest, []byte("Password must be at least 8 characters long")},
-		{"Duplicate email", "Bob", "dupe@example.com", "validPa$$word", csrfToken, http.StatusConflict, []byte("Email already in use")},
-		{"Invalid CSRF Token", "", "", "", "wrongToken", http.StatusBadRequest, []byte("Invalid CSRF token")},
+		{"Valid submission", "Bob", "bob@example.com", "validPa$$word", csrfToken, http.StatusSeeOther, []byte("Your signup was successful")},
+		{"Empty name", "", "bob@example.com", "validPa$$word", csrfToken, http.StatusOK, []byte("This field cannot be blank")},
+		{"Empty email", "Bob", "", "validPa$$word", csrfToken, http.StatusOK, []byte("This field cannot be blank")},
+		{"Empty password", "Bob", "bob@example.com", "", csrfToken, http.StatusOK, []byte("This field cannot be blank")},
+		{"Invalid email (incomplete domain)", "Bob", "bob@example.", "validPa$$word", csrfToken, http.StatusOK, []byte("This field is invalid")},
+		{"Invalid email (missing @)", "Bob", "bobexample.com", "validPa$$word", csrfToken, http.StatusOK, []byte("This field is invalid")},
+		{"Invalid email (missing local part)", "Bob", "@example.com", "validPa$$word", csrfToken, http.StatusOK, []byte("This field is invalid")},
+		{"Short password", "Bob", "bob@example.com", "pa$$word", csrfToken, http.StatusOK, []byte("This field is too short (minimum is 10)")},
+		{"Duplicate email", "Bob", "dupe@example.com", "validPa$$word", csrfToken, http.StatusOK, []byte("Address is already in use")},
+		{"Invalid CSRF Token", "", "", "", "wrongToken", http.StatusBadRequest, []byte("Bad Request")},
 	}
 
 	// Run the tests.
@@ -90,12 +329,19 @@ func TestSignupUser(t *testing.T) {
 			form.Add("csrf_token", tt.csrfToken)
 
 			// Send the POST request to the signup route.
-			code, _, body := ts.postForm(t, "/user/signup", form)
+			code, header, body := ts.postForm(t, "/user/signup", form)
 
 			// Check if the response code is as expected.
 			if code != tt.wantCode {
 				t.Errorf("want %d; got %d", tt.wantCode, code)
 			}
+			// Follow a redirect to the login page to see its flash.
+			if code == http.StatusSeeOther {
+				if header.Get("Location") != "/user/login" {
+					t.Errorf("want redirect to /user/login; got %q", header.Get("Location"))
+				}
+				_, _, body = ts.get(t, header.Get("Location"))
+			}
 			// Check if the response body contains the expected message.
 			if !bytes.Contains(body, tt.wantBody) {
 				t.Errorf("want body %s to contain %q", body, tt.wantBody)
@@ -103,3 +349,1544 @@ func TestSignupUser(t *testing.T) {
 		})
 	}
 }
+
+func TestEditSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Anonymous users are sent to the login page.
+	code, headers, _ := ts.get(t, "/s/pond/edit")
+	if code != http.StatusFound || headers.Get("Location") != "/user/login" {
+		t.Fatalf("want redirect to /user/login; got %d %q", code, headers.Get("Location"))
+	}
+
+	csrfToken := ts.login(t)
+
+	t.Run("Edit form", func(t *testing.T) {
+		code, _, body := ts.get(t, "/s/pond/edit")
+		if code != http.StatusOK {
+			t.Errorf("want %d; got %d", http.StatusOK, code)
+		}
+		if !bytes.Contains(body, []byte("An old silent pond...")) {
+			t.Errorf("want body to contain the current content")
+		}
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		title        string
+		content      string
+		visibility   string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Valid submission", "/s/pond/edit", "Updated", "New content", "public", http.StatusSeeOther, "/s/pond", nil},
+		{"Empty title", "/s/pond/edit", "", "New content", "public", http.StatusOK, "", []byte("This field cannot be blank")},
+		{"Long title", "/s/pond/edit", string(bytes.Repeat([]byte("a"), 101)), "New content", "public", http.StatusOK, "", []byte("This field is too long")},
+		{"Invalid visibility", "/s/pond/edit", "Updated", "New content", "secret", http.StatusOK, "", []byte("This field is invalid")},
+		{"Not the owner", "/s/forest/edit", "Updated", "New content", "public", http.StatusForbidden, "", nil},
+		{"Non-existent ID", "/s/missing/edit", "Updated", "New content", "public", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("visibility", tt.visibility)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDeleteSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{"Owner", "/s/pond/delete", http.StatusSeeOther},
+		{"Not the owner", "/s/forest/delete", http.StatusForbidden},
+		{"Non-existent ID", "/s/missing/delete", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestShowRevisions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Revisions", "/s/pond/revisions", http.StatusOK, []byte("An old pond")},
+		{"Revisions of non-existent ID", "/s/missing/revisions", http.StatusNotFound, nil},
+		{"Diff", "/s/pond/diff?from=1&to=2", http.StatusOK, []byte("&#43;An old silent pond...")},
+		{"Diff with unknown revision", "/s/pond/diff?from=1&to=3", http.StatusNotFound, nil},
+		{"Diff too large", "/s/forest/diff?from=1&to=2", http.StatusOK, []byte("too large to compare")},
+		{"Diff without revisions", "/s/pond/diff", http.StatusBadRequest, nil},
+		{"Diff of non-existent ID", "/s/missing/diff?from=1&to=2", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestArchive(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock snippet model only looks at the ID of a cursor.
+	first := (&models.Cursor{Created: time.Now(), ID: 1}).String()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Home links to next page", "/", http.StatusOK, []byte("/archive?after=")},
+		{"First page", "/archive", http.StatusOK, []byte("An old silent pond")},
+		{"Second page", "/archive?after=" + first, http.StatusOK, []byte("Over the wintry forest")},
+		{"Second page links back", "/archive?after=" + first, http.StatusOK, []byte("/archive?before=")},
+		{"Back to first page", "/archive?before=" + first, http.StatusOK, []byte("nothing to see here")},
+		{"Invalid cursor", "/archive?after=foo", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Form", "/search", http.StatusOK, []byte("name='q'")},
+		{"Match", "/search?q=pond", http.StatusOK, []byte("An old silent <mark>pond</mark>")},
+		{"Case insensitive", "/search?q=WINTRY", http.StatusOK, []byte("Over the <mark>wintry</mark> forest")},
+		{"Private snippets are hidden", "/search?q=autumn", http.StatusOK, []byte("No snippets matched")},
+		{"Unlisted snippets are hidden", "/search?q=dew", http.StatusOK, []byte("No snippets matched")},
+		{"Protected snippets are hidden", "/search?q=vaultsecret", http.StatusOK, []byte("No snippets matched")},
+		{"Protected titles are hidden", "/search?q=vault", http.StatusOK, []byte("No snippets matched")},
+		{"Query too long", "/search?q=" + strings.Repeat("a", 101), http.StatusOK, []byte("This field is too long")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	// Neither the content nor the title of a protected snippet shows up,
+	// whatever it is searched for.
+	for _, q := range []string{"vaultsecret", "vault", "token"} {
+		_, _, body := ts.get(t, "/search?q="+q)
+		if bytes.Contains(body, []byte("hvs.")) || bytes.Contains(body, []byte("/s/vault")) {
+			t.Errorf("want the protected snippet left out of the results for %q", q)
+		}
+	}
+}
+
+func TestCreateSnippetTags(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name     string
+		tags     string
+		wantCode int
+		wantBody []byte
+	}{
+		{"No tags", "", http.StatusSeeOther, nil},
+		{"Valid tags", "team-infra, Deploy,deploy, ", http.StatusSeeOther, nil},
+		{"Too many tags", "a,b,c,d,e,f", http.StatusOK, []byte("Too many tags (maximum is 5)")},
+		{"Tag too long", strings.Repeat("a", 31), http.StatusOK, []byte("is too long (maximum is 30 characters)")},
+		{"Invalid characters", "c++", http.StatusOK, []byte("may only contain letters, digits and hyphens")},
+		{"Leading hyphen", "-go", http.StatusOK, []byte("may only contain letters, digits and hyphens")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "A title")
+			form.Add("content", "Some content")
+			form.Add("visibility", "public")
+			form.Add("expires", "1h0m0s")
+			form.Add("tags", tt.tags)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestShowTag(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Tag on snippet page", "/s/pond", http.StatusOK, []byte("href='/tag/water'")},
+		{"Tagged snippets", "/tag/haiku", http.StatusOK, []byte("Over the wintry forest")},
+		{"Uppercase tag", "/tag/WATER", http.StatusOK, []byte("An old silent pond")},
+		{"Unused tag", "/tag/prose", http.StatusOK, []byte("nothing to see here")},
+		{"Invalid tag", "/tag/c++", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if bytes.Contains(body, []byte("First autumn morning")) {
+				t.Errorf("want private snippets to be hidden")
+			}
+		})
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/s/hello")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("<span>Go</span>")) {
+		t.Errorf("want the language to be shown")
+	}
+	if !bytes.Contains(body, []byte("<span style=")) {
+		t.Errorf("want the content to be highlighted")
+	}
+	if !bytes.Contains(body, []byte("&lt;hello&gt;")) || bytes.Contains(body, []byte("<hello>")) {
+		t.Errorf("want the content to be escaped")
+	}
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name     string
+		language string
+		wantCode int
+	}{
+		{"Auto-detect", "auto", http.StatusSeeOther},
+		{"Plain text", "", http.StatusSeeOther},
+		{"Known language", "python", http.StatusSeeOther},
+		{"Unknown language", "klingon", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "A title")
+			form.Add("content", "Some content")
+			form.Add("visibility", "public")
+			form.Add("expires", "1h0m0s")
+			form.Add("language", tt.language)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestMarkdownSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/s/runbook")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("<h1")) {
+		t.Errorf("want the content to be rendered")
+	}
+	if bytes.Contains(body, []byte("alert(1)</script>")) || bytes.Contains(body, []byte("javascript:")) {
+		t.Errorf("want the content to be sanitized")
+	}
+
+	// Like every other form, the preview endpoint needs a CSRF token.
+	code, _, _ = ts.postForm(t, "/snippet/preview", url.Values{"content": {"# Hi"}})
+	if code != http.StatusBadRequest {
+		t.Errorf("want %d without a CSRF token; got %d", http.StatusBadRequest, code)
+	}
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name     string
+		content  string
+		wantBody []byte
+	}{
+		{"Heading", "# Hi", []byte("<h1")},
+		{"Script", "<script>alert(1)</script>", nil},
+		{"Event handler", `<a href="https://example.com" onclick="alert(1)">x</a>`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("content", tt.content)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/preview", form)
+			if code != http.StatusOK {
+				t.Errorf("want %d; got %d", http.StatusOK, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if bytes.Contains(body, []byte("alert(1)")) {
+				t.Errorf("want body to be sanitized; got %q", body)
+			}
+		})
+	}
+
+	form := url.Values{}
+	form.Add("title", "A title")
+	form.Add("content", "# Runbook")
+	form.Add("visibility", "public")
+	form.Add("expires", "1h0m0s")
+	form.Add("format", "rtf")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, body = ts.postForm(t, "/snippet/create", form)
+	if code != http.StatusOK || !bytes.Contains(body, []byte("This field is invalid")) {
+		t.Errorf("want an unknown format to be rejected; got %d", code)
+	}
+}
+
+func TestRawSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantCode        int
+		wantBody        []byte
+		wantDisposition string
+	}{
+		{"Raw", "/s/pond/raw", http.StatusOK, []byte("An old silent pond..."), ""},
+		{"Raw HTML-like content", "/s/hello/raw", http.StatusOK, []byte(`println("<hello>")`), ""},
+		{"Download", "/s/hello/download", http.StatusOK, []byte("package main"), "attachment; filename=hello-world.go"},
+		{"Download Markdown", "/s/runbook/download", http.StatusOK, []byte("# Deploying"), "attachment; filename=deploy-runbook.md"},
+		{"Download plain text", "/s/pond/download", http.StatusOK, nil, "attachment; filename=an-old-silent-pond.txt"},
+		{"Unlisted", "/s/dew/raw", http.StatusOK, []byte("A world of dew"), ""},
+		{"Private", "/s/autumn/raw", http.StatusNotFound, nil, ""},
+		{"Protected", "/s/keys/raw", http.StatusForbidden, nil, ""},
+		{"Burn after reading", "/s/password/download", http.StatusForbidden, nil, ""},
+		{"Non-existent slug", "/s/missing/raw", http.StatusNotFound, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			if ct := header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+				t.Errorf("want Content-Type text/plain; got %q", ct)
+			}
+			if cd := header.Get("Content-Disposition"); cd != tt.wantDisposition {
+				t.Errorf("want Content-Disposition %q; got %q", tt.wantDisposition, cd)
+			}
+			// No session is started, so no cookie is sent.
+			if header.Get("Set-Cookie") != "" {
+				t.Errorf("want no Set-Cookie header")
+			}
+		})
+	}
+}
+
+func TestMultiFileSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/s/stack")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, name := range []string{"Dockerfile", "config.yaml", "run.sh", "/s/stack/archive.zip"} {
+		if !bytes.Contains(body, []byte(name)) {
+			t.Errorf("want body to contain %q", name)
+		}
+	}
+
+	code, header, body := ts.get(t, "/s/stack/archive.zip")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if cd := header.Get("Content-Disposition"); cd != "attachment; filename=deploy-stack.zip" {
+		t.Errorf("want Content-Disposition for deploy-stack.zip; got %q", cd)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Dockerfile":  "FROM golang:1.23\nCOPY . .\nRUN go build ./cmd/web\n",
+		"config.yaml": "addr: :4000\n",
+		"run.sh":      "#!/bin/sh\n./web -addr=:4000\n",
+	}
+	if len(zr.File) != len(want) {
+		t.Errorf("want %d files; got %d", len(want), len(zr.File))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want[f.Name] {
+			t.Errorf("want %s to hold %q; got %q", f.Name, want[f.Name], content)
+		}
+	}
+
+	// A single-file snippet is archived under its download name.
+	code, _, body = ts.get(t, "/s/pond/archive.zip")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("an-old-silent-pond.txt")) {
+		t.Errorf("want the archive to hold an-old-silent-pond.txt")
+	}
+
+	code, _, _ = ts.get(t, "/s/keys/archive.zip")
+	if code != http.StatusForbidden {
+		t.Errorf("want %d for a protected snippet; got %d", http.StatusForbidden, code)
+	}
+}
+
+func TestCreateMultiFileSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	type file struct{ name, language, content string }
+
+	tests := []struct {
+		name     string
+		filename string
+		files    []file
+		wantCode int
+		wantBody []byte
+	}{
+		{"Single file", "", nil, http.StatusSeeOther, nil},
+		{"Several files", "Dockerfile", []file{{"run.sh", "auto", "#!/bin/sh"}, {"app.yaml", "yaml", "a: 1"}}, http.StatusSeeOther, nil},
+		{"Main file without a name", "", []file{{"run.sh", "", "echo"}}, http.StatusOK, []byte("Name the main file")},
+		{"File without a name", "main.go", []file{{"", "", "echo"}}, http.StatusOK, []byte("Every file needs a name")},
+		{"Invalid name", "main.go", []file{{"../etc/passwd", "", "echo"}}, http.StatusOK, []byte("is invalid")},
+		{"Duplicate name", "main.go", []file{{"main.go", "go", "package main"}}, http.StatusOK, []byte("more than one file named")},
+		{"Empty file", "main.go", []file{{"run.sh", "", " "}}, http.StatusOK, []byte("is empty")},
+		{"Unknown language", "main.go", []file{{"run.sh", "klingon", "echo"}}, http.StatusOK, []byte("language of")},
+		{"Too many files", "main.go", []file{
+			{"1", "", "x"}, {"2", "", "x"}, {"3", "", "x"}, {"4", "", "x"}, {"5", "", "x"},
+			{"6", "", "x"}, {"7", "", "x"}, {"8", "", "x"}, {"9", "", "x"}, {"10", "", "x"},
+		}, http.StatusOK, []byte("Too many files")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "A title")
+			form.Add("content", "Some content")
+			form.Add("visibility", "public")
+			form.Add("expires", "1h0m0s")
+			form.Add("filename", tt.filename)
+			for _, f := range tt.files {
+				form.Add("file_name", f.name)
+				form.Add("file_language", f.language)
+				form.Add("file_content", f.content)
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestForkSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/s/pond")
+	if !bytes.Contains(body, []byte("Forked once")) || !bytes.Contains(body, []byte("href='/s/ripple'")) {
+		t.Errorf("want the forks to be listed")
+	}
+	if bytes.Contains(body, []byte("href='/s/splash'")) {
+		t.Errorf("want private forks to be left out")
+	}
+	if bytes.Contains(body, []byte("?fork=pond")) {
+		t.Errorf("want no fork link for anonymous users")
+	}
+
+	_, _, body = ts.get(t, "/s/ripple")
+	if !bytes.Contains(body, []byte("Forked from <a href='/s/pond'>")) {
+		t.Errorf("want the fork to link back to its parent")
+	}
+
+	csrfToken := ts.login(t)
+
+	_, _, body = ts.get(t, "/s/pond")
+	if !bytes.Contains(body, []byte("/snippet/create?fork=pond")) {
+		t.Errorf("want a fork link for logged in users")
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Fork form", "/snippet/create?fork=pond", http.StatusOK, []byte("value='An old silent pond'")},
+		{"Fork form remembers the parent", "/snippet/create?fork=pond", http.StatusOK, []byte("name='forked_from' value='pond'")},
+		{"Fork form copies files", "/snippet/create?fork=stack", http.StatusOK, []byte("value='config.yaml'")},
+		{"Private snippet", "/snippet/create?fork=autumn", http.StatusNotFound, nil},
+		{"Locked snippet", "/snippet/create?fork=keys", http.StatusNotFound, nil},
+		{"Burn after reading", "/snippet/create?fork=password", http.StatusNotFound, nil},
+		{"Non-existent slug", "/snippet/create?fork=missing", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name       string
+		forkedFrom string
+		wantCode   int
+	}{
+		{"Save fork", "pond", http.StatusSeeOther},
+		{"Save fork of private snippet", "autumn", http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "A title")
+			form.Add("content", "Some content")
+			form.Add("visibility", "public")
+			form.Add("expires", "1h0m0s")
+			form.Add("forked_from", tt.forkedFrom)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if code == http.StatusOK && !bytes.Contains(body, []byte("no longer available")) {
+				t.Errorf("want an error about the parent")
+			}
+		})
+	}
+}
+
+func TestComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/s/pond")
+	for _, want := range []string{"<em>dusk</em>", "id='comment-2'", "href='#L1'", `id="L1"`} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	if bytes.Contains(body, []byte("<script>alert(1)")) {
+		t.Errorf("want comments to be sanitized")
+	}
+	if bytes.Contains(body, []byte("action='/s/pond/comments'")) {
+		t.Errorf("want no comment form for anonymous users")
+	}
+
+	form := url.Values{}
+	form.Add("comment", "Nice")
+	code, header, _ := ts.postForm(t, "/s/pond/comments", form)
+	if code != http.StatusBadRequest && !(code == http.StatusSeeOther && header.Get("Location") == "/user/login") {
+		t.Errorf("want posting without logging in to be refused; got %d", code)
+	}
+
+	csrfToken := ts.login(t)
+
+	_, _, body = ts.get(t, "/s/pond")
+	if !bytes.Contains(body, []byte("action='/s/pond/comments/1/delete'")) {
+		t.Errorf("want a delete button on the user's own comment")
+	}
+	if bytes.Contains(body, []byte("action='/s/pond/comments/2/delete'")) {
+		t.Errorf("want no delete button on other users' comments")
+	}
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		comment   string
+		line      string
+		wantCode  int
+		wantBody  []byte
+		wantRedir string
+	}{
+		{"Valid comment", "/s/pond/comments", "Nice", "", http.StatusSeeOther, nil, "/s/pond#comment-3"},
+		{"Valid line comment", "/s/pond/comments", "Nice", "1", http.StatusSeeOther, nil, "/s/pond#comment-3"},
+		{"Blank comment", "/s/pond/comments", "", "", http.StatusOK, []byte("This field cannot be blank"), ""},
+		{"Line out of range", "/s/pond/comments", "Nice", "2", http.StatusOK, []byte("Pick a line between 1 and 1"), ""},
+		{"Line not a number", "/s/pond/comments", "Nice", "one", http.StatusOK, []byte("Pick a line between 1 and 1"), ""},
+		{"Private snippet", "/s/autumn/comments", "Nice", "", http.StatusNotFound, nil, ""},
+		{"Locked snippet", "/s/keys/comments", "Nice", "", http.StatusSeeOther, nil, "/s/keys"},
+		{"Delete own comment", "/s/pond/comments/1/delete", "", "", http.StatusSeeOther, nil, "/s/pond#comments"},
+		{"Delete other user's comment", "/s/pond/comments/2/delete", "", "", http.StatusForbidden, nil, ""},
+		{"Delete comment on another snippet", "/s/forest/comments/1/delete", "", "", http.StatusNotFound, nil, ""},
+		{"Delete non-existent comment", "/s/pond/comments/99/delete", "", "", http.StatusNotFound, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("comment", tt.comment)
+			form.Add("line", tt.line)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if tt.wantRedir != "" && header.Get("Location") != tt.wantRedir {
+				t.Errorf("want redirect to %q; got %q", tt.wantRedir, header.Get("Location"))
+			}
+		})
+	}
+}
+
+func TestStars(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/")
+	if !bytes.Contains(body, []byte("Most starred this week")) || !bytes.Contains(body, []byte("&#9733; 2")) {
+		t.Errorf("want the most starred snippets on the home page")
+	}
+
+	_, _, body = ts.get(t, "/s/pond")
+	if !bytes.Contains(body, []byte("&#9733; 2")) {
+		t.Errorf("want the star count on the snippet page")
+	}
+	if bytes.Contains(body, []byte("action='/s/pond/star'")) {
+		t.Errorf("want no star button for anonymous users")
+	}
+
+	code, header, _ := ts.get(t, "/user/stars")
+	if code != http.StatusFound || header.Get("Location") != "/user/login" {
+		t.Errorf("want /user/stars to need logging in; got %d", code)
+	}
+
+	csrfToken := ts.login(t)
+
+	_, _, body = ts.get(t, "/s/pond")
+	if !bytes.Contains(body, []byte("<button>Unstar</button>")) {
+		t.Errorf("want an unstar button on a starred snippet")
+	}
+	_, _, body = ts.get(t, "/s/hello")
+	if !bytes.Contains(body, []byte("<button>Star</button>")) {
+		t.Errorf("want a star button on a snippet which isn't starred")
+	}
+
+	code, _, body = ts.get(t, "/user/stars")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("An old silent pond")) {
+		t.Errorf("want the starred snippets to be listed")
+	}
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		csrfToken string
+		wantCode  int
+		wantFlash string
+	}{
+		{"Star", "/s/hello/star", csrfToken, http.StatusSeeOther, "Snippet starred!"},
+		{"Unstar", "/s/pond/star", csrfToken, http.StatusSeeOther, "Star removed"},
+		{"Private snippet", "/s/autumn/star", csrfToken, http.StatusNotFound, ""},
+		{"Non-existent slug", "/s/missing/star", csrfToken, http.StatusNotFound, ""},
+		{"Invalid CSRF token", "/s/hello/star", "wrongToken", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", tt.csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantFlash == "" {
+				return
+			}
+			_, _, body := ts.get(t, header.Get("Location"))
+			if !bytes.Contains(body, []byte(tt.wantFlash)) {
+				t.Errorf("want flash %q", tt.wantFlash)
+			}
+		})
+	}
+}
+
+func TestSnippetViews(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The same session viewing a snippet twice only counts once.
+	ts.get(t, "/s/forest")
+	ts.get(t, "/s/forest")
+	// Nor are owners counted viewing their own snippets.
+	ts.login(t)
+	ts.get(t, "/s/pond")
+
+	counts := bySnippet(app.viewCounter.Take())
+	if len(counts) != 1 || counts[3] != 1 {
+		t.Errorf("want one view of snippet 3; got %v", counts)
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Own snippet", "/s/pond/stats", http.StatusOK, []byte("Viewed 8 times in the last 30 days")},
+		{"Other user's snippet", "/s/forest/stats", http.StatusForbidden, nil},
+		{"Non-existent slug", "/s/missing/stats", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	_, _, body := ts.get(t, "/s/pond/stats")
+	if n := bytes.Count(body, []byte("<meter")); n != 30 {
+		t.Errorf("want a row for each of the 30 days; got %d", n)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Keep the emails which are sent so that we can follow their links.
+	var mail bytes.Buffer
+	app.mailer = &mailer.Log{Logger: log.New(&mail, "", 0), From: "no-reply@example.com"}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("name", "Carol")
+	form.Add("email", "carol@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("signup: want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	link := regexp.MustCompile(`https://snippetbox\.example\.com(/user/verify\?token=\S+)`).FindStringSubmatch(mail.String())
+	if link == nil {
+		t.Fatalf("want a verification link to be emailed; got %q", mail.String())
+	}
+
+	form = url.Values{}
+	form.Add("email", "carol@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, _, body = ts.postForm(t, "/user/login", form)
+	if code != http.StatusOK || !bytes.Contains(body, []byte("Please verify your email address")) {
+		t.Errorf("want unverified users not to be logged in; got %d", code)
+	}
+
+	expired := app.signToken(verifyPurpose, &models.User{ID: 3, Email: "carol@example.com"}, time.Now().Add(-time.Minute))
+	otherEmail := app.signToken(verifyPurpose, &models.User{ID: 3, Email: "mallory@example.com"}, time.Now().Add(time.Hour))
+	otherPurpose := app.signToken("other", &models.User{ID: 3, Email: "carol@example.com"}, time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantRedir string
+	}{
+		{"Valid link", link[1], "/user/login"},
+		{"Tampered link", strings.Replace(link[1], "token=3.", "token=1.", 1), "/user/verify/resend"},
+		{"Expired link", "/user/verify?token=" + url.QueryEscape(expired), "/user/verify/resend"},
+		{"Link for another address", "/user/verify?token=" + url.QueryEscape(otherEmail), "/user/verify/resend"},
+		{"Token for another purpose", "/user/verify?token=" + url.QueryEscape(otherPurpose), "/user/verify/resend"},
+		{"Missing token", "/user/verify", "/user/verify/resend"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, tt.urlPath)
+			if code != http.StatusSeeOther {
+				t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+			}
+			if got := header.Get("Location"); got != tt.wantRedir {
+				t.Errorf("want redirect to %q; got %q", tt.wantRedir, got)
+			}
+		})
+	}
+
+	if user, err := app.users.Get(3); err != nil || !user.Verified {
+		t.Errorf("want the valid link to verify the account")
+	}
+
+	// Start again with the account unverified, as it was before the link was
+	// followed.
+	app.users = &mock.UserModel{}
+
+	for _, tt := range []struct {
+		name     string
+		email    string
+		wantMail bool
+	}{
+		{"Resend to unverified user", "carol@example.com", true},
+		{"Resend to verified user", "alice@example.com", false},
+		{"Resend to unknown address", "nobody@example.com", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mail.Reset()
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, "/user/verify/resend", form)
+			if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+				t.Errorf("want a redirect to /user/login; got %d", code)
+			}
+			if got := strings.Contains(mail.String(), "To: "+tt.email); got != tt.wantMail {
+				t.Errorf("want email sent to be %t; got %t", tt.wantMail, got)
+			}
+		})
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApplication(t)
+
+	var mail bytes.Buffer
+	app.mailer = &mailer.Log{Logger: log.New(&mail, "", 0), From: "no-reply@example.com"}
+
+	// Log in on one server, then reset the password through another, so
+	// that the two have separate sessions.
+	loggedIn := newTestServer(t, app.routes())
+	defer loggedIn.Close()
+	loggedIn.login(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/forgot")
+	csrfToken := extractCSRFToken(t, body)
+
+	for _, tt := range []struct {
+		name     string
+		email    string
+		wantMail bool
+	}{
+		{"Unknown address", "nobody@example.com", false},
+		{"Registered address", "alice@example.com", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mail.Reset()
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, "/user/forgot", form)
+			if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+				t.Errorf("want a redirect to /user/login; got %d", code)
+			}
+			if got := strings.Contains(mail.String(), "https://snippetbox.example.com/user/reset/valid-token"); got != tt.wantMail {
+				t.Errorf("want reset link sent to be %t; got %t", tt.wantMail, got)
+			}
+		})
+	}
+
+	code, header, body := ts.get(t, "/user/reset/valid-token")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("action='/user/reset/valid-token'")) {
+		t.Errorf("want the reset form; got %d", code)
+	}
+	if got := header.Get("Referrer-Policy"); got != "no-referrer" {
+		t.Errorf("want Referrer-Policy no-referrer; got %q", got)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		password string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Short password", "valid-token", "pa$$word", http.StatusOK, []byte("This field is too short")},
+		{"Invalid token", "used-token", "validPa$$word", http.StatusOK, []byte("invalid, has expired or has already been used")},
+		{"Valid token", "valid-token", "validPa$$word", http.StatusSeeOther, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/reset/"+tt.token, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	// The session from before the reset has been logged out.
+	code, header, _ = loggedIn.get(t, "/snippet/create")
+	if code != http.StatusFound || header.Get("Location") != "/user/login" {
+		t.Errorf("want the old session to be logged out; got %d", code)
+	}
+}
+
+func TestPasswordResetUnlocksAccount(t *testing.T) {
+	app := newTestApplication(t)
+	for i := 0; i < accountLockFailures; i++ {
+		app.loginAttempts.Fail("email:alice@example.com", time.Now().Add(-loginFailureWindow))
+	}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/reset/valid-token")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/reset/valid-token", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	if failures, _, _ := app.loginAttempts.Get("email:alice@example.com"); failures != 0 {
+		t.Errorf("want the failed logins cleared; got %d", failures)
+	}
+
+	// The new password works straight away.
+	form = url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther || header.Get("Location") != "/snippet/create" {
+		t.Errorf("want to be logged in; got %d %q", code, header.Get("Location"))
+	}
+}
+
+func TestAccount(t *testing.T) {
+	app := newTestApplication(t)
+
+	var mail bytes.Buffer
+	app.mailer = &mailer.Log{Logger: log.New(&mail, "", 0), From: "no-reply@example.com"}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/user/account")
+	if code != http.StatusFound || header.Get("Location") != "/user/login" {
+		t.Errorf("want /user/account to need logging in; got %d", code)
+	}
+
+	csrfToken := ts.login(t)
+
+	code, _, body := ts.get(t, "/user/account")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("alice@example.com")) {
+		t.Errorf("want the account page to show the user's details; got %d", code)
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		fields   map[string]string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Change name", "/user/account/name", map[string]string{"name": "Alicia"}, http.StatusSeeOther, nil},
+		{"Blank name", "/user/account/name", map[string]string{"name": ""}, http.StatusOK, []byte("This field cannot be blank")},
+		{"Change email", "/user/account/email", map[string]string{"email": "alicia@example.com", "email_password": "validPa$$word"}, http.StatusSeeOther, nil},
+		{"Invalid email", "/user/account/email", map[string]string{"email": "alicia@", "email_password": "validPa$$word"}, http.StatusOK, []byte("This field is invalid")},
+		{"Email with wrong password", "/user/account/email", map[string]string{"email": "alicia@example.com", "email_password": "wrongPa$$word"}, http.StatusOK, []byte("Password is incorrect")},
+		{"Duplicate email", "/user/account/email", map[string]string{"email": "dupe@example.com", "email_password": "validPa$$word"}, http.StatusOK, []byte("Address is already in use")},
+		{"Change password", "/user/account/password", map[string]string{"current_password": "validPa$$word", "new_password": "newValidPa$$word"}, http.StatusSeeOther, nil},
+		{"Short password", "/user/account/password", map[string]string{"current_password": "validPa$$word", "new_password": "pa$$word"}, http.StatusOK, []byte("This field is too short")},
+		{"Password with wrong current password", "/user/account/password", map[string]string{"current_password": "wrongPa$$word", "new_password": "newValidPa$$word"}, http.StatusOK, []byte("Password is incorrect")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			for k, v := range tt.fields {
+				form.Add(k, v)
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantBody != nil && !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if code == http.StatusSeeOther && header.Get("Location") != "/user/account" {
+				t.Errorf("want redirect to /user/account; got %q", header.Get("Location"))
+			}
+		})
+	}
+
+	// Changing the password keeps the session which changed it logged in.
+	code, _, _ = ts.get(t, "/user/account")
+	if code != http.StatusOK {
+		t.Errorf("want the session to stay logged in; got %d", code)
+	}
+
+	// The new address only counts once the link emailed to it is followed,
+	// and the current address is told about the change.
+	if !strings.Contains(mail.String(), "mailer: email to alice@example.com") {
+		t.Errorf("want the current address to be notified; got %q", mail.String())
+	}
+	link := regexp.MustCompile(`mailer: email to alicia@example\.com\n(?s:.*?)https://snippetbox\.example\.com(/user/account/email/confirm\?token=\S+)`).FindStringSubmatch(mail.String())
+	if link == nil {
+		t.Fatalf("want a confirmation link sent to the new address; got %q", mail.String())
+	}
+	user, err := app.users.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.PendingEmail != "alicia@example.com" || !user.Verified {
+		t.Errorf("want the current address kept until the new one is confirmed; got %q, pending %q, verified %t", user.Email, user.PendingEmail, user.Verified)
+	}
+
+	code, _, body = ts.get(t, "/user/account")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("alicia@example.com (waiting for you")) {
+		t.Errorf("want the account page to show the pending address; got %d", code)
+	}
+
+	// The session can still log in with the current address.
+	ts.login(t)
+
+	otherAddress := app.signToken(changeEmailPurpose, &models.User{ID: 1, PendingEmail: "mallory@example.com"}, time.Now().Add(time.Hour))
+	verifyToken := app.signToken(verifyPurpose, &models.User{ID: 1, Email: "alice@example.com"}, time.Now().Add(time.Hour))
+
+	for _, tt := range []struct {
+		name      string
+		urlPath   string
+		wantEmail string
+		wantFlash string
+	}{
+		{"Link for another address", "/user/account/email/confirm?token=" + url.QueryEscape(otherAddress), "alice@example.com", "invalid or has expired"},
+		{"Verification token", "/user/account/email/confirm?token=" + url.QueryEscape(verifyToken), "alice@example.com", "invalid or has expired"},
+		{"Valid link", link[1], "alicia@example.com", "Your email address has been changed"},
+		{"Link used twice", link[1], "alicia@example.com", "invalid or has expired"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, tt.urlPath)
+			if code != http.StatusSeeOther || header.Get("Location") != "/user/account" {
+				t.Fatalf("want a redirect to /user/account; got %d %q", code, header.Get("Location"))
+			}
+			_, _, body := ts.get(t, "/user/account")
+			if !bytes.Contains(body, []byte(tt.wantFlash)) {
+				t.Errorf("want flash %q", tt.wantFlash)
+			}
+			user, err := app.users.Get(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Email != tt.wantEmail {
+				t.Errorf("want email %q; got %q", tt.wantEmail, user.Email)
+			}
+		})
+	}
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	code, _, body := ts.get(t, "/user/2fa")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("src='data:image/png;base64,")) {
+		t.Fatalf("want a QR code rendered on the server; got %d", code)
+	}
+	secret := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindSubmatch(body)
+	if secret == nil {
+		t.Fatal("want the secret to be shown")
+	}
+
+	// The secret stays the same until it is confirmed.
+	_, _, body = ts.get(t, "/user/2fa")
+	if !bytes.Contains(body, secret[0]) {
+		t.Errorf("want the same secret on every visit")
+	}
+
+	current, err := totp.GenerateCode(string(secret[1]), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		password string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Blank code", "", "validPa$$word", http.StatusOK, []byte("This field cannot be blank")},
+		{"Blank password", current, "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Wrong password", current, "wrongPa$$word", http.StatusOK, []byte("Password is incorrect")},
+		{"Wrong code", "000000", "validPa$$word", http.StatusOK, []byte("Code is incorrect")},
+		{"Valid code", current, "validPa$$word", http.StatusOK, []byte("Save these recovery codes")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/2fa/enable", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			// A form sent back with errors still shows the same secret.
+			if tt.code != current || tt.password != "validPa$$word" {
+				if !bytes.Contains(body, secret[0]) {
+					t.Errorf("want the secret to be shown again")
+				}
+			}
+			if tt.code == current && tt.password == "validPa$$word" {
+				if n := len(regexp.MustCompile(`<li><code>[a-z2-9]{4}-[a-z2-9]{4}</code></li>`).FindAll(body, -1)); n != 10 {
+					t.Errorf("want 10 recovery codes; got %d", n)
+				}
+			}
+		})
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	app := newTestApplication(t)
+	secret, err := app.users.TOTPSecret(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logInWithPassword gives the password of the user with two-factor
+	// authentication on a new server, and returns it with its CSRF token.
+	logInWithPassword := func(t *testing.T) (*testServer, string) {
+		ts := newTestServer(t, app.routes())
+
+		_, _, body := ts.get(t, "/user/login")
+		csrfToken := extractCSRFToken(t, body)
+
+		form := url.Values{}
+		form.Add("email", "dave@example.com")
+		form.Add("password", "validPa$$word")
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, "/user/login", form)
+		if code != http.StatusSeeOther || header.Get("Location") != "/user/login/2fa" {
+			t.Fatalf("want a redirect to the code form; got %d %q", code, header.Get("Location"))
+		}
+		return ts, csrfToken
+	}
+
+	tests := []struct {
+		name         string
+		code         string
+		wantLoggedIn bool
+	}{
+		{"Authenticator code", current, true},
+		{"Recovery code", "ABCD-EFGH", true},
+		{"Wrong code", "123456", false},
+		{"Wrong recovery code", "abcd-efgj", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, csrfToken := logInWithPassword(t)
+			defer ts.Close()
+
+			// The password alone doesn't log the user in.
+			code, _, _ := ts.get(t, "/snippet/create")
+			if code != http.StatusFound {
+				t.Errorf("want to be logged out before giving the code; got %d", code)
+			}
+
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/user/login/2fa", form)
+			if tt.wantLoggedIn && code != http.StatusSeeOther {
+				t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+			}
+			if !tt.wantLoggedIn && !bytes.Contains(body, []byte("Code is incorrect")) {
+				t.Errorf("want an error about the code")
+			}
+
+			code, _, _ = ts.get(t, "/snippet/create")
+			if loggedIn := code == http.StatusOK; loggedIn != tt.wantLoggedIn {
+				t.Errorf("want logged in to be %t; got %t", tt.wantLoggedIn, loggedIn)
+			}
+		})
+	}
+
+	t.Run("Too many wrong codes", func(t *testing.T) {
+		ts, csrfToken := logInWithPassword(t)
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("code", "123456")
+		form.Add("csrf_token", csrfToken)
+		for i := 1; i < maxCodeAttempts; i++ {
+			ts.postForm(t, "/user/login/2fa", form)
+		}
+		code, header, _ := ts.postForm(t, "/user/login/2fa", form)
+		if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+			t.Errorf("want to be sent back to the login form; got %d", code)
+		}
+
+		// Even the right code doesn't work now.
+		form.Set("code", current)
+		code, header, _ = ts.postForm(t, "/user/login/2fa", form)
+		if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+			t.Errorf("want the pending login to be gone; got %d", code)
+		}
+	})
+
+	t.Run("No pending login", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, header, _ := ts.get(t, "/user/login/2fa")
+		if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+			t.Errorf("want a redirect to /user/login; got %d", code)
+		}
+	})
+}
+
+func TestLoginThrottling(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		failures     int
+		email        string
+		password     string
+		wantLoggedIn bool
+		wantFlash    []byte
+	}{
+		{"Free failures", "email:alice@example.com", accountFreeFailures, "alice@example.com", "validPa$$word", true, nil},
+		{"Account backoff", "email:alice@example.com", accountFreeFailures + 1, "alice@example.com", "validPa$$word", false, []byte("Please wait 1 second")},
+		{"Account locked", "email:alice@example.com", accountLockFailures, "alice@example.com", "validPa$$word", false, []byte("This account is locked")},
+		{"Email case", "email:alice@example.com", accountLockFailures, "Alice@Example.com", "validPa$$word", false, []byte("This account is locked")},
+		{"Client backoff", "ip:127.0.0.1", ipFreeFailures + 1, "alice@example.com", "validPa$$word", false, []byte("Please wait 1 second")},
+		{"Other account", "email:bob@example.com", accountLockFailures, "alice@example.com", "validPa$$word", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			for i := 0; i < tt.failures; i++ {
+				app.loginAttempts.Fail(tt.key, time.Now().Add(-loginFailureWindow))
+			}
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+			csrfToken := extractCSRFToken(t, body)
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, "/user/login", form)
+			if code != http.StatusSeeOther {
+				t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+			}
+			if tt.wantFlash != nil {
+				if header.Get("Location") != "/user/login" {
+					t.Errorf("want a redirect to /user/login; got %q", header.Get("Location"))
+				}
+				_, _, body = ts.get(t, "/user/login")
+				if !bytes.Contains(body, tt.wantFlash) {
+					t.Errorf("want body to contain %q", tt.wantFlash)
+				}
+			}
+
+			code, _, _ = ts.get(t, "/snippet/create")
+			if loggedIn := code == http.StatusOK; loggedIn != tt.wantLoggedIn {
+				t.Errorf("want logged in to be %t; got %t", tt.wantLoggedIn, loggedIn)
+			}
+		})
+	}
+
+	t.Run("Failures are counted", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+		csrfToken := extractCSRFToken(t, body)
+
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", "wrongPa$$word")
+		form.Add("csrf_token", csrfToken)
+		for i := 0; i < accountFreeFailures; i++ {
+			code, _, body := ts.postForm(t, "/user/login", form)
+			if code != http.StatusOK || !bytes.Contains(body, []byte("Email or Password is incorrect")) {
+				t.Fatalf("want the login form with an error; got %d", code)
+			}
+		}
+
+		for _, key := range []string{"email:alice@example.com", "ip:127.0.0.1"} {
+			if failures, _, _ := app.loginAttempts.Get(key); failures != accountFreeFailures {
+				t.Errorf("want %d failures for %s; got %d", accountFreeFailures, key, failures)
+			}
+		}
+
+		// The next wrong password is one too many, so even the right one
+		// has to wait now.
+		app.loginAttempts.Fail("email:alice@example.com", time.Now().Add(-loginFailureWindow))
+		form.Set("password", "validPa$$word")
+		code, header, _ := ts.postForm(t, "/user/login", form)
+		if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+			t.Errorf("want a redirect to /user/login; got %d", code)
+		}
+	})
+
+	t.Run("Wrong passwords on the account page", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		csrfToken := ts.login(t)
+
+		form := url.Values{}
+		form.Add("current_password", "wrongPa$$word")
+		form.Add("new_password", "newValidPa$$word")
+		form.Add("csrf_token", csrfToken)
+		for i := 0; i < accountFreeFailures; i++ {
+			_, _, body := ts.postForm(t, "/user/account/password", form)
+			if !bytes.Contains(body, []byte("Password is incorrect")) {
+				t.Fatalf("want an error about the password")
+			}
+		}
+		if failures, _, _ := app.loginAttempts.Get("email:alice@example.com"); failures != accountFreeFailures {
+			t.Errorf("want %d failures; got %d", accountFreeFailures, failures)
+		}
+
+		// Once the account has to wait, even the right password is refused.
+		app.loginAttempts.Fail("email:alice@example.com", time.Now().Add(-loginFailureWindow))
+		form.Set("current_password", "validPa$$word")
+		code, _, body := ts.postForm(t, "/user/account/password", form)
+		if code != http.StatusOK || !bytes.Contains(body, []byte("Please wait")) {
+			t.Errorf("want the password change to be held up; got %d", code)
+		}
+	})
+
+	t.Run("Wrong codes lock the account", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		secret, err := app.users.TOTPSecret(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current, err := totp.GenerateCode(secret, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, body := ts.get(t, "/user/login")
+		csrfToken := extractCSRFToken(t, body)
+
+		form := url.Values{}
+		form.Add("email", "dave@example.com")
+		form.Add("password", "validPa$$word")
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, "/user/login", form)
+		if code != http.StatusSeeOther || header.Get("Location") != "/user/login/2fa" {
+			t.Fatalf("want a redirect to the code form; got %d", code)
+		}
+
+		// Someone else has been guessing the password in the meantime, and
+		// one more wrong code locks the account.
+		for i := 1; i < accountLockFailures-1; i++ {
+			app.loginAttempts.Fail("email:dave@example.com", time.Now().Add(-loginFailureWindow))
+		}
+		form = url.Values{}
+		form.Add("code", "123456")
+		form.Add("csrf_token", csrfToken)
+		ts.postForm(t, "/user/login/2fa", form)
+		ts.postForm(t, "/user/login/2fa", form)
+
+		form.Set("code", current)
+		code, header, _ = ts.postForm(t, "/user/login/2fa", form)
+		if code != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+			t.Errorf("want a redirect to /user/login; got %d", code)
+		}
+		_, _, body = ts.get(t, "/user/login")
+		if !bytes.Contains(body, []byte("This account is locked")) {
+			t.Errorf("want a message about the lock")
+		}
+		code, _, _ = ts.get(t, "/snippet/create")
+		if code != http.StatusFound {
+			t.Errorf("want to stay logged out; got %d", code)
+		}
+	})
+}
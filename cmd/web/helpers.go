@@ -3,11 +3,18 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"github.com/TeslaMode1X/snippetbox/pkg/forms"
+	"github.com/TeslaMode1X/snippetbox/pkg/highlight"
 	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"github.com/TeslaMode1X/snippetbox/pkg/totp"
+	"github.com/go-chi/chi/v5"
 	"github.com/justinas/nosurf"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // render renders a specific HTML template with the provided data.
@@ -91,3 +98,390 @@ func (app *application) authenticatedUser(r *http.Request) *models.User {
 	}
 	return user
 }
+
+// snippetFromURL fetches the snippet identified by the {slug} URL parameter.
+// If no matching snippet is found or the snippet is private to somebody else,
+// it sends a 404 Not Found response and returns false.
+func (app *application) snippetFromURL(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	// Use the SnippetModel object's GetBySlug method to retrieve the data for
+	// a specific record based on its slug. If no matching record is found,
+	// return a 404 Not Found response.
+	s, err := app.snippets.GetBySlug(chi.URLParam(r, "slug"))
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return nil, false
+	} else if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	// Private snippets are hidden from everyone but their owner. We respond
+	// exactly as if the snippet didn't exist, so that its existence isn't
+	// given away either.
+	if !app.canView(r, s) {
+		app.notFound(w)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// forkSource looks up the snippet with the given slug for the current user
+// to fork. It returns models.ErrNoRecord unless canRead allows it.
+func (app *application) forkSource(r *http.Request, slug string) (*models.Snippet, error) {
+	s, err := app.snippets.GetBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	if !app.canRead(r, s) {
+		return nil, models.ErrNoRecord
+	}
+	return s, nil
+}
+
+// rawSnippetFromURL works like snippetFromURL for the raw and download
+// endpoints. These run without a session, so nobody counts as logged in and
+// private snippets are always hidden. Password protected snippets can't be
+// unlocked and burn-after-reading snippets can't be burned without one
+// either, so those are refused with a 403 Forbidden response.
+func (app *application) rawSnippetFromURL(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if s.Protected || s.BurnAfterReading {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// downloadName returns the name of the file a snippet's main file is
+// downloaded as. That is the name the author gave it or, failing that, the
+// snippet's titleName followed by the extension for its format or language.
+func downloadName(s *models.Snippet) string {
+	if s.Filename != "" {
+		return s.Filename
+	}
+
+	if s.Format == models.FormatMarkdown {
+		return titleName(s) + ".md"
+	}
+	return titleName(s) + highlight.Extension(s.Language)
+}
+
+// titleName turns the title of a snippet into something which can be used in
+// a file name: the title in lowercase, with runs of anything other than
+// letters and digits replaced by hyphens.
+func titleName(s *models.Snippet) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s.Title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+
+	if b.Len() == 0 {
+		return "snippet"
+	}
+	return b.String()
+}
+
+// historyFromURL works like snippetFromURL for pages which show the revisions
+// of a snippet. The history of a burn-after-reading snippet would give its
+// content away without burning it, so only the owner gets to see it, and
+// readers of a protected snippet are sent to the password prompt first.
+func (app *application) historyFromURL(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if s.BurnAfterReading && !app.isOwner(r, s) {
+		app.notFound(w)
+		return nil, false
+	}
+
+	if app.isLocked(r, s) {
+		http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// snippetURL returns the path of the page which shows the snippet.
+func snippetURL(s *models.Snippet) string {
+	return "/s/" + s.Slug
+}
+
+// isLocked reports whether the snippet is protected by a password which the
+// current user hasn't entered yet. Owners never need to enter it.
+func (app *application) isLocked(r *http.Request, s *models.Snippet) bool {
+	return s.Protected && !app.isOwner(r, s) && !app.session.GetBool(r, unlockedKey(s.ID))
+}
+
+// unlockedKey returns the session key which remembers that the password of
+// the snippet has been entered.
+func unlockedKey(id int) string {
+	return fmt.Sprintf("unlocked:%d", id)
+}
+
+// canView reports whether the current user is allowed to see the snippet.
+func (app *application) canView(r *http.Request, s *models.Snippet) bool {
+	return s.Visibility != models.VisibilityPrivate || app.isOwner(r, s)
+}
+
+// canRead reports whether the current user can read the content of the
+// snippet right now, for example to fork it or to comment on it. That rules
+// out private snippets of other users, protected snippets which haven't been
+// unlocked and burn-after-reading snippets, whose content is meant to be
+// seen only once.
+func (app *application) canRead(r *http.Request, s *models.Snippet) bool {
+	return app.canView(r, s) && !app.isLocked(r, s) && !s.BurnAfterReading
+}
+
+// isOwner reports whether the snippet belongs to the current user.
+func (app *application) isOwner(r *http.Request, s *models.Snippet) bool {
+	user := app.authenticatedUser(r)
+	return user != nil && user.ID == s.UserID
+}
+
+// ownedSnippetFromURL works like snippetFromURL, but additionally sends a 403
+// Forbidden response unless the snippet belongs to the authenticated user.
+func (app *application) ownedSnippetFromURL(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if !app.isOwner(r, s) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// pageRequest builds the request for a page of snippets from the 'after' or
+// 'before' query string parameter, using the configured page size and order.
+func (app *application) pageRequest(r *http.Request) (models.PageRequest, error) {
+	req := models.PageRequest{
+		Limit:       app.pageSize,
+		NewestFirst: app.newestFirst,
+	}
+
+	var err error
+	if token := r.URL.Query().Get("after"); token != "" {
+		req.After, err = models.ParseCursor(token)
+	} else if token := r.URL.Query().Get("before"); token != "" {
+		req.Before, err = models.ParseCursor(token)
+	}
+	return req, err
+}
+
+// newPagination returns the links to the pages on either side of the page,
+// which are served from the given path.
+func newPagination(path string, page *models.Page) *pagination {
+	p := &pagination{}
+	if page.Prev != nil {
+		p.PrevURL = path + "?before=" + page.Prev.String()
+	}
+	if page.Next != nil {
+		p.NextURL = path + "?after=" + page.Next.String()
+	}
+	return p
+}
+
+// sendVerification emails a user a link which verifies their email address.
+func (app *application) sendVerification(user *models.User) error {
+	token := app.signToken(verifyPurpose, user, time.Now().Add(verificationTTL))
+	link := app.baseURL + "/user/verify?token=" + url.QueryEscape(token)
+
+	body := fmt.Sprintf(`Hi %s,
+
+Please follow this link to verify your email address for Snippetbox:
+
+%s
+
+The link expires in %s. If you didn't sign up or change your address, you
+can ignore this email.
+`, user.Name, link, humanDuration(verificationTTL))
+
+	return app.mailer.Send(user.Email, "Verify your email address", body)
+}
+
+// sendEmailChange emails a link to the address a user wants to change to,
+// which confirms the change.
+func (app *application) sendEmailChange(user *models.User) error {
+	token := app.signToken(changeEmailPurpose, user, time.Now().Add(verificationTTL))
+	link := app.baseURL + "/user/account/email/confirm?token=" + url.QueryEscape(token)
+
+	body := fmt.Sprintf(`Hi %s,
+
+Please follow this link to make this the email address of your Snippetbox
+account:
+
+%s
+
+The link expires in %s. Until it is followed, the account keeps its current
+address. If you didn't ask for this, you can ignore this email.
+`, user.Name, link, humanDuration(verificationTTL))
+
+	return app.mailer.Send(user.PendingEmail, "Confirm your new email address", body)
+}
+
+// sendEmailChangeNotice tells a user at their current address that they have
+// asked to change it, in case somebody else did so.
+func (app *application) sendEmailChangeNotice(user *models.User) error {
+	body := fmt.Sprintf(`Hi %s,
+
+Somebody asked to change the email address of your Snippetbox account to
+%s. We've emailed that address a link to confirm the change, and until it
+is followed the account keeps this address.
+
+If this wasn't you, please log in and change your password.
+`, user.Name, user.PendingEmail)
+
+	return app.mailer.Send(user.Email, "Your email address is being changed", body)
+}
+
+// sendPasswordReset emails a user a link to reset their password.
+func (app *application) sendPasswordReset(user *models.User) error {
+	token, err := app.users.NewResetToken(user.ID, resetTokenTTL)
+	if err != nil {
+		return err
+	}
+	link := app.baseURL + "/user/reset/" + url.PathEscape(token)
+
+	body := fmt.Sprintf(`Hi %s,
+
+Somebody asked to reset the password of your Snippetbox account. Follow this
+link to choose a new one:
+
+%s
+
+The link expires in %s and can only be used once. If you didn't ask for
+this, you can ignore this email and your password will stay the same.
+`, user.Name, link, humanDuration(resetTokenTTL))
+
+	return app.mailer.Send(user.Email, "Reset your password", body)
+}
+
+// checkPassword checks the password in the given form field against the
+// current user's password and reports whether it is right. If it isn't, the
+// reason is added to the field's errors for the caller to render the form
+// with. Wrong passwords count as failed logins, so that a stolen session
+// can't be used to guess the password any faster than the login form can.
+func (app *application) checkPassword(r *http.Request, form *forms.Form, field string) (bool, error) {
+	email := app.authenticatedUser(r).Email
+
+	locked, wait, err := app.startLogin(r, email)
+	if err != nil {
+		return false, err
+	}
+	if locked > 0 {
+		form.Errors.Add(field, lockedMessage(locked))
+		return false, nil
+	} else if wait > 0 {
+		form.Errors.Add(field, waitMessage(wait))
+		return false, nil
+	}
+
+	// ErrNotVerified still means the password was right, which is all that
+	// matters for a user who is already logged in. A wrong password has
+	// already been counted by startLogin.
+	_, err = app.users.Authenticate(email, form.Get(field))
+	if err == models.ErrInvalidCredentials {
+		form.Errors.Add(field, "Password is incorrect")
+		return false, nil
+	} else if err != nil && err != models.ErrNotVerified {
+		return false, err
+	}
+
+	err = app.forgiveLogin(r, email)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// logIn logs the user in on the current session.
+func (app *application) logIn(r *http.Request, user *models.User) {
+	app.session.Put(r, "userID", user.ID)
+	app.session.Put(r, "sessionVersion", user.SessionVersion)
+}
+
+// pendingUserID returns the ID of the user who has given their password but
+// not yet their two-factor code, or 0 if there is no such user or they took
+// longer than pendingLoginTTL.
+func (app *application) pendingUserID(r *http.Request) int {
+	id := app.session.GetInt(r, "pendingUserID")
+	if id == 0 {
+		return 0
+	}
+	// The time is kept as Unix seconds, since the session can only hold
+	// types which gob knows about without registering them.
+	since := time.Unix(int64(app.session.GetInt(r, "pendingSince")), 0)
+	if time.Since(since) > pendingLoginTTL {
+		app.clearPendingLogin(r)
+		return 0
+	}
+	return id
+}
+
+func (app *application) clearPendingLogin(r *http.Request) {
+	app.session.Remove(r, "pendingUserID")
+	app.session.Remove(r, "pendingSince")
+	app.session.Remove(r, "pendingAttempts")
+}
+
+// checkLoginCode reports whether the code is either the user's current
+// two-factor code or one of their recovery codes. Either is used up by a
+// successful check, so the same code doesn't work twice.
+func (app *application) checkLoginCode(id int, code string) (bool, error) {
+	secret, err := app.users.TOTPSecret(id)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(code, secret, time.Now()); ok {
+		err = app.users.UseTOTPStep(id, step)
+	} else {
+		err = app.users.UseRecoveryCode(id, totp.HashRecoveryCode(code))
+	}
+	if err == models.ErrInvalidToken {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// failLogin is called after a wrong password, which startLogin has already
+// counted. If that failure locked the account it tells the user so and sends
+// them back to the login form, and returns false; the caller should then
+// stop. It also returns false after sending a server error.
+func (app *application) failLogin(w http.ResponseWriter, r *http.Request, email string) bool {
+	locked, err := app.accountLocked(email)
+	if err != nil {
+		app.serverError(w, err)
+		return false
+	}
+	if locked > 0 {
+		app.session.Put(r, "flash", lockedMessage(locked))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return false
+	}
+	return true
+}
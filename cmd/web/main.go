@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/TeslaMode1X/snippetbox/pkg/mailer"
 	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"github.com/TeslaMode1X/snippetbox/pkg/models/memory"
 	"github.com/TeslaMode1X/snippetbox/pkg/models/mysql"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/golangcollege/sessions"
@@ -12,6 +17,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -23,17 +32,72 @@ type application struct {
 	errorLog *log.Logger
 	infoLog  *log.Logger
 	users    interface {
-		Insert(string, string, string) error
+		Insert(string, string, string) (int, error)
 		Authenticate(string, string) (int, error)
 		Get(int) (*models.User, error)
+		GetByEmail(string) (*models.User, error)
+		Verify(int) error
+		UpdateName(int, string) error
+		SetPendingEmail(int, string) error
+		ConfirmEmail(int, string) error
+		ChangePassword(int, string) error
+		NewResetToken(int, time.Duration) (string, error)
+		ResetPassword(string, string) (int, error)
+		EnableTOTP(int, string, int64, [][]byte) error
+		DisableTOTP(int) error
+		TOTPSecret(int) (string, error)
+		UseTOTPStep(int, int64) error
+		UseRecoveryCode(int, []byte) error
 	}
 	session  *sessions.Session
 	snippets interface {
-		Insert(string, string, string) (int, error)
+		Insert(*models.Snippet, string) (string, error)
 		Get(int) (*models.Snippet, error)
-		Latest() ([]*models.Snippet, error)
+		GetBySlug(string) (*models.Snippet, error)
+		Page(models.PageRequest) (*models.Page, error)
+		Search(string, int) ([]*models.Snippet, error)
+		Forks(int) ([]*models.Snippet, error)
+		ForkCount(int) (int, error)
+		Update(int, string, string, string) error
+		Delete(int) error
+		Unlock(int, string) error
+		Burn(int) (*models.Snippet, error)
+		DeleteExpired(time.Time) (int, error)
+		Revisions(int) ([]*models.Revision, error)
+		Revision(int, int) (*models.Revision, error)
+	}
+	comments interface {
+		Insert(int, int, int, string) (int, error)
+		Get(int) (*models.Comment, error)
+		ForSnippet(int) ([]*models.Comment, error)
+		Delete(int) error
+	}
+	stars interface {
+		Toggle(int, int) (bool, error)
+		Starred(int, int) (bool, error)
+		Count(int) (int, error)
+		ForUser(int) ([]*models.Snippet, error)
+		MostStarred(time.Time, int) ([]*models.Snippet, error)
+	}
+	views interface {
+		Add(map[models.ViewDay]int) error
+		Daily(int, time.Time) ([]*models.DailyViews, error)
 	}
+	loginAttempts interface {
+		Get(string) (int, time.Time, error)
+		Fail(string, time.Time) (int, error)
+		Forgive(string) error
+		Clear(string) error
+	}
+	viewCounter   *viewCounter
+	mailer        mailer.Mailer
+	baseURL       string
+	secret        []byte
 	templateCache map[string]*template.Template
+	unlockLimiter *rateLimiter
+	expiryOptions []time.Duration
+	pageSize      int
+	newestFirst   bool
 }
 
 func main() {
@@ -55,6 +119,43 @@ func main() {
 	// bytes long.
 	secret := flag.String("secret", "s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge", "Secret")
 
+	// Define a command-line flag for the expiry durations which are offered
+	// on the create snippet form, as a comma-separated list of Go durations.
+	// "Never" and an exact date and time are always offered as well.
+	expiry := flag.String("expiry", "10m,1h,24h,168h,8760h", "Comma-separated snippet expiry options")
+
+	// Define a command-line flag for how often expired snippets are purged
+	// from the database.
+	sweepInterval := flag.Duration("sweep-interval", time.Hour, "Interval between purges of expired snippets")
+
+	// Define command-line flags for how long repeat views of a snippet from
+	// the same session are counted as one, and how often counted views are
+	// written to the database.
+	viewWindow := flag.Duration("view-window", 30*time.Minute, "Window in which repeat views from a session count once")
+	viewFlushInterval := flag.Duration("view-flush-interval", time.Minute, "Interval between writes of counted views")
+
+	// Define command-line flags for how many snippets are listed per page,
+	// and whether the newest or the oldest snippets are listed first.
+	pageSize := flag.Int("page-size", 10, "Number of snippets per page")
+	sortOrder := flag.String("sort", "newest", "Order of snippet listings (newest or oldest)")
+
+	// Define command-line flags for sending email. Links in emails start with
+	// the base URL, rather than whatever Host header a request came with. If
+	// no SMTP host is given, emails are written to the -mail-log file (or to
+	// stdout) instead of being sent, which is handy in development.
+	baseURL := flag.String("base-url", "https://localhost:4000", "Base URL for links in emails")
+	smtpHost := flag.String("smtp-host", "", "SMTP server host (leave empty to log emails instead)")
+	smtpPort := flag.Int("smtp-port", 587, "SMTP server port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	smtpFrom := flag.String("smtp-from", "Snippetbox <no-reply@snippetbox.local>", "Sender of emails")
+	mailLog := flag.String("mail-log", "", "File to log emails to when there is no SMTP host")
+
+	// Define a command-line flag for where failed logins are counted. Counts
+	// kept in memory are lost on restart and aren't shared between servers,
+	// so they only suit a single instance.
+	loginAttemptStore := flag.String("login-attempts", "mysql", "Where failed logins are counted (mysql or memory)")
+
 	// Importantly, we use the flag.Parse() function to parse the command-line
 	// arguments. This reads in the command-line flag value and assigns it to the 'addr'
 	// variable. You need to call this *before* you use the 'addr' variable
@@ -71,6 +172,43 @@ func main() {
 	// and the log.Llongfile flag to include the file name and line number in the log entries.
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Llongfile)
 
+	expiryOptions, err := parseExpiryOptions(*expiry)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	if *pageSize < 1 {
+		errorLog.Fatal("page-size must be at least 1")
+	}
+	if *sortOrder != "newest" && *sortOrder != "oldest" {
+		errorLog.Fatalf("sort must be newest or oldest, not %q", *sortOrder)
+	}
+
+	if *loginAttemptStore != "mysql" && *loginAttemptStore != "memory" {
+		errorLog.Fatalf("login-attempts must be mysql or memory, not %q", *loginAttemptStore)
+	}
+
+	var mail mailer.Mailer
+	if *smtpHost != "" {
+		mail = &mailer.SMTP{
+			Host:     *smtpHost,
+			Port:     *smtpPort,
+			Username: *smtpUsername,
+			Password: *smtpPassword,
+			From:     *smtpFrom,
+		}
+	} else {
+		out := os.Stdout
+		if *mailLog != "" {
+			out, err = os.OpenFile(*mailLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				errorLog.Fatal(err)
+			}
+			defer out.Close()
+		}
+		mail = &mailer.Log{Logger: log.New(out, "MAIL\t", log.Ldate|log.Ltime), From: *smtpFrom}
+	}
+
 	// To keep the main() function tidy I've put the code for creating a connec
 	// pool into the separate openDB() function below. We pass openDB() the DSN
 	// from the command-line flag.
@@ -103,7 +241,23 @@ func main() {
 		session:       session,
 		users:         &mysql.UserModel{DB: db},
 		snippets:      &mysql.SnippetModel{DB: db},
+		comments:      &mysql.CommentModel{DB: db},
+		stars:         &mysql.StarModel{DB: db},
+		views:         &mysql.ViewModel{DB: db},
+		loginAttempts: &mysql.LoginAttemptModel{DB: db},
+		viewCounter:   newViewCounter(*viewWindow),
+		mailer:        mail,
+		baseURL:       strings.TrimSuffix(*baseURL, "/"),
+		secret:        []byte(*secret),
 		templateCache: templateCache,
+		// Allow five wrong passwords per snippet every fifteen minutes.
+		unlockLimiter: newRateLimiter(5, 15*time.Minute),
+		expiryOptions: expiryOptions,
+		pageSize:      *pageSize,
+		newestFirst:   *sortOrder == "newest",
+	}
+	if *loginAttemptStore == "memory" {
+		app.loginAttempts = &memory.LoginAttemptModel{}
 	}
 
 	// Initialize a tls.Config struct to hold the non-default TLS settings we want
@@ -128,14 +282,79 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// Stop the server and any background jobs cleanly when the process is
+	// interrupted or asked to terminate.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The view flusher gets a context of its own, which is only cancelled
+	// once the server has shut down. Its last flush then includes the views
+	// counted by requests which were still in flight when the signal came.
+	flushCtx, stopFlushing := context.WithCancel(context.Background())
+	defer stopFlushing()
+
+	// Start the background jobs. The WaitGroup lets us wait for them to
+	// finish whatever they're doing before we exit.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		app.sweepExpired(ctx, *sweepInterval)
+	}()
+	go func() {
+		defer wg.Done()
+		app.flushViews(flushCtx, *viewFlushInterval)
+	}()
+
+	// Once the context is cancelled, give in-flight requests a few seconds
+	// to complete and then shut the server down.
+	shutdownErr := make(chan error)
+	go func() {
+		<-ctx.Done()
+		infoLog.Print("Shutting down server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := srv.Shutdown(shutdownCtx)
+		stopFlushing()
+		shutdownErr <- err
+	}()
+
 	// Log the starting address of the server.
 	infoLog.Printf("Starting server on %s", *addr)
 
 	// Start the HTTP server by calling ListenAndServe on the http.Server struct.
-	// This method will block and run until an error occurs or the server is stopped.
+	// This method will block until an error occurs or the server is shut down,
+	// in which case it returns http.ErrServerClosed.
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	// If ListenAndServe returns an error, log it and terminate the application.
-	errorLog.Fatal(err)
+	// If ListenAndServe returns any other error, log it and terminate the application.
+	if !errors.Is(err, http.ErrServerClosed) {
+		errorLog.Fatal(err)
+	}
+
+	if err = <-shutdownErr; err != nil {
+		errorLog.Fatal(err)
+	}
+	wg.Wait()
+
+	infoLog.Print("Stopped server")
+}
+
+// parseExpiryOptions parses a comma-separated list of durations, such as
+// "1h,24h,168h", as given to the -expiry flag.
+func parseExpiryOptions(s string) ([]time.Duration, error) {
+	options := []time.Duration{}
+	for _, field := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("expiry option %s is not positive", d)
+		}
+		options = append(options, d)
+	}
+	return options, nil
 }
 
 // The openDB() function wraps sql.Open() and returns a sql.DB connection pool
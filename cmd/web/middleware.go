@@ -31,6 +31,15 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Sessions from before the user's session version last went up, for
+		// example because their password was reset, are logged out.
+		if user.SessionVersion != app.session.GetInt(r, "sessionVersion") {
+			app.session.Remove(r, "userID")
+			app.session.Remove(r, "sessionVersion")
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Otherwise, we know that the request is coming from a valid,
 		// authenticated (logged in) user. We create a new copy of the
 		// request with the user information added to the request context, and
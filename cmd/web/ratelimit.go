@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter keeps track of failed attempts per key (such as a snippet ID)
+// and refuses further attempts once max failures have been recorded within
+// the sliding window.
+type rateLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[int][]time.Time
+}
+
+func newRateLimiter(max int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		max:      max,
+		window:   window,
+		failures: map[int][]time.Time{},
+	}
+}
+
+// Allow reports whether another attempt may be made for the key.
+func (l *rateLimiter) Allow(key int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.prune(key)) < l.max
+}
+
+// Fail records a failed attempt for the key.
+func (l *rateLimiter) Fail(key int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.failures[key] = append(l.prune(key), time.Now())
+}
+
+// prune drops the failures for the key which have fallen out of the window
+// and returns the ones which are left. Keys without any recent failures are
+// removed altogether, so that the map doesn't grow forever.
+func (l *rateLimiter) prune(key int) []time.Time {
+	cutoff := time.Now().Add(-l.window)
+
+	recent := l.failures[key]
+	for len(recent) > 0 && recent[0].Before(cutoff) {
+		recent = recent[1:]
+	}
+
+	if len(recent) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = recent
+	return recent
+}
@@ -21,23 +21,78 @@ func (app *application) routes() http.Handler {
 	dynamicMiddleware := alice.New(app.session.Enable, noSurf, app.authenticate)
 
 	r.Get("/", dynamicMiddleware.ThenFunc(app.home).ServeHTTP)
+	r.Get("/archive", dynamicMiddleware.ThenFunc(app.archive).ServeHTTP)
+	r.Get("/search", dynamicMiddleware.ThenFunc(app.search).ServeHTTP)
+	r.Get("/tag/{name}", dynamicMiddleware.ThenFunc(app.showTag).ServeHTTP)
 
 	r.Route("/snippet", func(r chi.Router) {
-		r.Get("/{id}", dynamicMiddleware.ThenFunc(app.showSnippet).ServeHTTP)
 		// New snippet
 		r.Get("/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippetForm).ServeHTTP)
 		r.Post("/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippet).ServeHTTP) // Use Post for resource creation
+		r.Post("/preview", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.previewMarkdown).ServeHTTP)
+		// Old links which used the numeric ID
+		r.Get("/{id}", dynamicMiddleware.ThenFunc(app.redirectSnippet).ServeHTTP)
+	})
+
+	// Snippets are addressed by their random slug rather than their ID, so
+	// that nobody can find them by counting upwards.
+	r.Route("/s", func(r chi.Router) {
+		r.Get("/{slug}", dynamicMiddleware.ThenFunc(app.showSnippet).ServeHTTP)
+		r.Post("/{slug}/burn", dynamicMiddleware.ThenFunc(app.burnSnippet).ServeHTTP)
+		r.Post("/{slug}/unlock", dynamicMiddleware.ThenFunc(app.unlockSnippet).ServeHTTP)
+		// Plain content, without the session and CSRF middleware, so that
+		// tools like curl don't get cookies they have no use for.
+		r.Get("/{slug}/raw", app.rawSnippet)
+		r.Get("/{slug}/download", app.downloadSnippet)
+		r.Get("/{slug}/archive.zip", app.archiveSnippet)
+		// Changing an existing snippet (owner only)
+		r.Get("/{slug}/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editSnippetForm).ServeHTTP)
+		r.Post("/{slug}/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editSnippet).ServeHTTP)
+		r.Post("/{slug}/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteSnippet).ServeHTTP)
+		// View counts, for the owner
+		r.Get("/{slug}/stats", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.showSnippetStats).ServeHTTP)
+		// Stars
+		r.Post("/{slug}/star", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.toggleStar).ServeHTTP)
+		// Comments
+		r.Post("/{slug}/comments", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.addComment).ServeHTTP)
+		r.Post("/{slug}/comments/{id}/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteComment).ServeHTTP)
+		// Revision history
+		r.Get("/{slug}/revisions", dynamicMiddleware.ThenFunc(app.showRevisions).ServeHTTP)
+		r.Get("/{slug}/diff", dynamicMiddleware.ThenFunc(app.showDiff).ServeHTTP)
 	})
 
 	r.Route("/user", func(r chi.Router) {
 		// User creating
 		r.Get("/signup", dynamicMiddleware.ThenFunc(app.signupUserForm).ServeHTTP)
 		r.Post("/signup", dynamicMiddleware.ThenFunc(app.signupUser).ServeHTTP)
+		// Email verification
+		r.Get("/verify", dynamicMiddleware.ThenFunc(app.verifyUser).ServeHTTP)
+		r.Get("/verify/resend", dynamicMiddleware.ThenFunc(app.resendVerificationForm).ServeHTTP)
+		r.Post("/verify/resend", dynamicMiddleware.ThenFunc(app.resendVerification).ServeHTTP)
+		// Password reset
+		r.Get("/forgot", dynamicMiddleware.ThenFunc(app.forgotPasswordForm).ServeHTTP)
+		r.Post("/forgot", dynamicMiddleware.ThenFunc(app.forgotPassword).ServeHTTP)
+		r.Get("/reset/{token}", dynamicMiddleware.ThenFunc(app.resetPasswordForm).ServeHTTP)
+		r.Post("/reset/{token}", dynamicMiddleware.ThenFunc(app.resetPassword).ServeHTTP)
 		// User logging
 		r.Get("/login", dynamicMiddleware.ThenFunc(app.loginUserForm).ServeHTTP)
 		r.Post("/login", dynamicMiddleware.ThenFunc(app.loginUser).ServeHTTP)
+		r.Get("/login/2fa", dynamicMiddleware.ThenFunc(app.loginCodeForm).ServeHTTP)
+		r.Post("/login/2fa", dynamicMiddleware.ThenFunc(app.loginCode).ServeHTTP)
 		// User exit
 		r.Post("/logout", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.logoutUser).ServeHTTP)
+		// Account settings
+		r.Get("/account", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.account).ServeHTTP)
+		r.Post("/account/name", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.updateName).ServeHTTP)
+		r.Post("/account/email", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.updateEmail).ServeHTTP)
+		r.Get("/account/email/confirm", dynamicMiddleware.ThenFunc(app.confirmEmail).ServeHTTP)
+		r.Post("/account/password", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.changePassword).ServeHTTP)
+		// Two-factor authentication
+		r.Get("/2fa", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.twoFactor).ServeHTTP)
+		r.Post("/2fa/enable", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.enableTwoFactor).ServeHTTP)
+		r.Post("/2fa/disable", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.disableTwoFactor).ServeHTTP)
+		// Starred snippets
+		r.Get("/stars", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userStars).ServeHTTP)
 	})
 
 	// testing purposes
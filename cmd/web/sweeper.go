@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// sweepExpired permanently deletes expired snippets once every interval, until
+// ctx is cancelled. Expired snippets are hidden by the queries which read
+// them, so this only keeps the snippets table from growing forever.
+func (app *application) sweepExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.sweep()
+		}
+	}
+}
+
+// sweep runs a single pass of the sweeper and logs what it did.
+func (app *application) sweep() {
+	n, err := app.snippets.DeleteExpired(time.Now())
+	if err != nil {
+		// Whatever was removed before the error is still gone, so report
+		// it as well.
+		app.errorLog.Printf("sweeper: %s (after removing %d snippets)", err, n)
+		return
+	}
+	app.infoLog.Printf("sweeper: removed %d expired snippets", n)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Capture what the sweeper logs so that we can check it.
+	var buf bytes.Buffer
+	app.infoLog = log.New(&buf, "", 0)
+
+	app.sweep()
+
+	// The mock model reports that it removed three snippets.
+	if got := buf.String(); !strings.Contains(got, "removed 3 expired snippets") {
+		t.Errorf("want log to report the removed snippets; got %q", got)
+	}
+}
+
+func TestSweepExpiredStops(t *testing.T) {
+	app := newTestApplication(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.sweepExpired(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	// Let the sweeper run a few times before asking it to stop.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper did not stop after its context was cancelled")
+	}
+}
@@ -1,13 +1,27 @@
 package main
 
 import (
+	"fmt"
+	"github.com/TeslaMode1X/snippetbox/pkg/diff"
 	"github.com/TeslaMode1X/snippetbox/pkg/forms"
+	"github.com/TeslaMode1X/snippetbox/pkg/highlight"
+	"github.com/TeslaMode1X/snippetbox/pkg/markdown"
 	"github.com/TeslaMode1X/snippetbox/pkg/models"
 	"html/template"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+// pagination holds the links shown by the pagination.partial.tmpl template.
+// An empty link means there is no page in that direction.
+type pagination struct {
+	PrevURL string
+	NextURL string
+}
+
 type templateData struct {
 	CurrentYear       int
 	CSRFToken         string
@@ -15,7 +29,33 @@ type templateData struct {
 	Form              *forms.Form
 	AuthenticatedUser *models.User
 	Snippet           *models.Snippet
+	Parent            *models.Snippet
+	Forks             []*models.Snippet
+	ForkCount         int
 	Snippets          []*models.Snippet
+	Files             []*models.File
+	Burned            bool
+	ExpiryOptions     []time.Duration
+	Languages         []highlight.Language
+	Pagination        *pagination
+	Query             string
+	Tag               string
+	Comments          []*models.Comment
+	Stars             int
+	Starred           bool
+	MostStarred       []*models.Snippet
+	Views             []*models.DailyViews
+	TotalViews        int
+	MaxViews          int
+	Revisions         []*models.Revision
+	From              *models.Revision
+	To                *models.Revision
+	Diff              []diff.Hunk
+	DiffTooLarge      bool
+	Token             string
+	TOTPSecret        string
+	QRCode            template.URL
+	RecoveryCodes     []string
 }
 
 func humanDate(t time.Time) string {
@@ -27,8 +67,138 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// humanDuration describes a duration in the largest whole unit which fits it
+// exactly, for example "2 hours" or "1 week".
+func humanDuration(d time.Duration) string {
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+
+	for _, u := range units {
+		if d >= u.size && d%u.size == 0 {
+			n := int(d / u.size)
+			if n == 1 {
+				return fmt.Sprintf("1 %s", u.name)
+			}
+			return fmt.Sprintf("%d %ss", n, u.name)
+		}
+	}
+	return d.String()
+}
+
+// searchTerms returns a case-insensitive pattern which matches any of the
+// words in a search query, or nil if the query has no words.
+func searchTerms(query string) *regexp.Regexp {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+	return regexp.MustCompile(`(?i)` + strings.Join(words, "|"))
+}
+
+// markMatches escapes text for use in HTML and wraps each word from the
+// search query which appears in it in a <mark> element.
+func markMatches(query, text string) template.HTML {
+	re := searchTerms(query)
+	if re == nil {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// excerpt shortens text to around 200 characters, keeping the first word
+// from the search query which appears in it in view.
+func excerpt(query, text string) string {
+	const size, lead = 200, 60
+
+	runes := []rune(text)
+	if len(runes) <= size {
+		return text
+	}
+
+	start := 0
+	if re := searchTerms(query); re != nil {
+		if loc := re.FindStringIndex(text); loc != nil {
+			start = max(utf8.RuneCountInString(text[:loc[0]])-lead, 0)
+		}
+	}
+	end := min(start+size, len(runes))
+	start = max(end-size, 0)
+
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
+
+// highlightCode returns the content as syntax highlighted HTML. The HTML is
+// built by chroma, which escapes all of the content, so it is safe to mark it
+// as trusted. If highlighting fails the content is shown as plain text.
+func highlightCode(content, language string) template.HTML {
+	h, err := highlight.HTML(content, language)
+	if err != nil {
+		return template.HTML(template.HTMLEscapeString(content))
+	}
+	return template.HTML(h)
+}
+
+// highlightLines works like highlightCode, but numbers the lines so that
+// they can be linked to.
+func highlightLines(content, language string) template.HTML {
+	h, err := highlight.NumberedHTML(content, language)
+	if err != nil {
+		return template.HTML("<pre>" + template.HTMLEscapeString(content) + "</pre>")
+	}
+	return template.HTML(h)
+}
+
+// renderMarkdown returns the content rendered from Markdown. The HTML has
+// been through the sanitizer in the markdown package, so it is safe to mark
+// it as trusted. If rendering fails the content is shown as plain text.
+func renderMarkdown(content string) template.HTML {
+	h, err := markdown.HTML(content)
+	if err != nil {
+		return template.HTML(template.HTMLEscapeString(content))
+	}
+	return template.HTML(h)
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":      humanDate,
+	"humanDuration":  humanDuration,
+	"markMatches":    markMatches,
+	"highlight":      highlightCode,
+	"highlightLines": highlightLines,
+	"markdown":       renderMarkdown,
+	"languageName":   highlight.Name,
+	"excerpt":        excerpt,
+	"sub":            func(a, b int) int { return a - b },
 }
 
 func newTemplateCache(dir string) (map[string]*template.Template, error) {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"html/template"
+	"strings"
 	"testing"
 	"time"
 )
@@ -38,3 +40,76 @@ func TestHumanDate(t *testing.T) {
 	}
 
 }
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		want string
+	}{
+		{"Minutes", 10 * time.Minute, "10 minutes"},
+		{"One hour", time.Hour, "1 hour"},
+		{"Hours", 36 * time.Hour, "36 hours"},
+		{"Days", 48 * time.Hour, "2 days"},
+		{"One week", 7 * 24 * time.Hour, "1 week"},
+		{"One year", 365 * 24 * time.Hour, "1 year"},
+		{"Seconds", 90 * time.Second, "1m30s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := humanDuration(tt.d); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMarkMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		text  string
+		want  template.HTML
+	}{
+		{"No query", "", "An old pond", "An old pond"},
+		{"One word", "pond", "An old pond", "An old <mark>pond</mark>"},
+		{"Several words", "old pond", "An old pond", "An <mark>old</mark> <mark>pond</mark>"},
+		{"Case insensitive", "POND", "An old pond", "An old <mark>pond</mark>"},
+		{"Escaped", "b", "<b>bold</b>", "&lt;<mark>b</mark>&gt;<mark>b</mark>old&lt;/<mark>b</mark>&gt;"},
+		{"Special characters", "a+b", "a+b=c", "<mark>a+b</mark>=c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := markMatches(tt.query, tt.text)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	long := strings.Repeat("a ", 150) + "pond" + strings.Repeat(" b", 150)
+
+	tests := []struct {
+		name  string
+		query string
+		text  string
+		want  string
+	}{
+		{"Short text", "pond", "An old pond", "An old pond"},
+		{"Match in view", "pond", long, "…" + long[240:440] + "…"},
+		{"No match", "frog", long, long[:200] + "…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := excerpt(tt.query, tt.text)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/TeslaMode1X/snippetbox/pkg/mailer"
 	"github.com/TeslaMode1X/snippetbox/pkg/models/mock"
 	"github.com/golangcollege/sessions"
 	"html"
@@ -36,7 +37,19 @@ func newTestApplication(t *testing.T) *application {
 		infoLog:       log.New(ioutil.Discard, "", 0),
 		session:       session,
 		snippets:      &mock.SnippetModel{},
+		comments:      &mock.CommentModel{},
+		stars:         &mock.StarModel{},
+		views:         &mock.ViewModel{},
+		loginAttempts: &mock.LoginAttemptModel{},
+		viewCounter:   newViewCounter(30 * time.Minute),
+		mailer:        &mailer.Log{Logger: log.New(ioutil.Discard, "", 0), From: "no-reply@example.com"},
+		baseURL:       "https://snippetbox.example.com",
+		secret:        []byte("3dSm5MnygFHh7XidAtbskXrjbwfoJcbJ"),
 		templateCache: templateCache,
+		unlockLimiter: newRateLimiter(5, 15*time.Minute),
+		expiryOptions: []time.Duration{time.Hour, 24 * time.Hour, 365 * 24 * time.Hour},
+		pageSize:      10,
+		newestFirst:   true,
 		users:         &mock.UserModel{},
 	}
 }
@@ -125,3 +138,22 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	// Return the response status code, headers, and body.
 	return rs.StatusCode, rs.Header, body
 }
+
+// login signs the test server's client in as the mock user and returns the
+// CSRF token for the session, so that it can be used in later POST requests.
+func (ts *testServer) login(t *testing.T) string {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	return csrfToken
+}
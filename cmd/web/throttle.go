@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Failed logins are counted per account, keyed by the email address which was
+// tried, and per client IP address. A few failures are free; after that each
+// further attempt has to wait twice as long as the one before, and an account
+// with accountLockFailures failures is locked for accountLockDuration.
+// Failures older than loginFailureWindow are forgotten.
+const (
+	loginFailureWindow  = time.Hour
+	accountFreeFailures = 3
+	ipFreeFailures      = 10
+	loginBackoffBase    = time.Second
+	loginBackoffMax     = 5 * time.Minute
+	accountLockFailures = 10
+	accountLockDuration = 15 * time.Minute
+)
+
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// clientKey identifies the client by the address it connected from. Headers
+// such as X-Forwarded-For are ignored, since anyone can set them.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// loginWait returns how much longer a client has to wait before trying again,
+// given the number of failures so far, the time of the last one and how many
+// failures are allowed before there is any wait at all.
+func loginWait(failures, free int, last, now time.Time) time.Duration {
+	if failures <= free || now.Sub(last) > loginFailureWindow {
+		return 0
+	}
+
+	wait := loginBackoffBase
+	for i := free + 1; i < failures && wait < loginBackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > loginBackoffMax {
+		wait = loginBackoffMax
+	}
+
+	wait -= now.Sub(last)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// lockWait returns how much longer an account stays locked for, given the
+// number of failures so far and the time of the last one, or 0 if it isn't
+// locked.
+func lockWait(failures int, last, now time.Time) time.Duration {
+	if failures < accountLockFailures {
+		return 0
+	}
+
+	wait := last.Add(accountLockDuration).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// accountLocked returns how long the account with the email stays locked
+// for, or 0 if it isn't locked.
+func (app *application) accountLocked(email string) (time.Duration, error) {
+	failures, last, err := app.loginAttempts.Get(accountKey(email))
+	if err != nil {
+		return 0, err
+	}
+	return lockWait(failures, last, time.Now()), nil
+}
+
+// startLogin checks whether the client may try to log in to the account with
+// the email, taking the failures of both into account, and if so counts the
+// attempt as failed before the password has even been checked. It returns
+// how long the account stays locked and how long the client has to wait; if
+// both are 0 the attempt may go ahead, and the caller must call forgiveLogin
+// once the password turns out to be right.
+//
+// Counting the attempt first stops concurrent attempts from all finding the
+// same number of failures and getting past the limits together. An attempt
+// which finds that others were counted after it checked has to wait, just as
+// it would have had they come first, and stays counted as failed.
+func (app *application) startLogin(r *http.Request, email string) (locked, wait time.Duration, err error) {
+	now := time.Now()
+
+	accountFailures, accountLast, err := app.loginAttempts.Get(accountKey(email))
+	if err != nil {
+		return 0, 0, err
+	}
+	clientFailures, clientLast, err := app.loginAttempts.Get(clientKey(r))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	locked = lockWait(accountFailures, accountLast, now)
+	wait = loginWait(accountFailures, accountFreeFailures, accountLast, now)
+	if w := loginWait(clientFailures, ipFreeFailures, clientLast, now); w > wait {
+		wait = w
+	}
+	if locked > 0 || wait > 0 {
+		return locked, wait, nil
+	}
+
+	reset := now.Add(-loginFailureWindow)
+	n, err := app.loginAttempts.Fail(accountKey(email), reset)
+	if err != nil {
+		return 0, 0, err
+	}
+	m, err := app.loginAttempts.Fail(clientKey(r), reset)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The failures before this attempt are one fewer than the counts it got
+	// back. More than it checked means others came in between, only a
+	// moment ago.
+	if n-1 > accountFailures {
+		locked = lockWait(n-1, now, now)
+		wait = loginWait(n-1, accountFreeFailures, now, now)
+	}
+	if m-1 > clientFailures {
+		if w := loginWait(m-1, ipFreeFailures, now, now); w > wait {
+			wait = w
+		}
+	}
+	return locked, wait, nil
+}
+
+// forgiveLogin takes back the failure which startLogin counted for an
+// attempt to log in to the account with the email, once the password has
+// turned out to be right.
+func (app *application) forgiveLogin(r *http.Request, email string) error {
+	err := app.loginAttempts.Forgive(accountKey(email))
+	if err != nil {
+		return err
+	}
+	return app.loginAttempts.Forgive(clientKey(r))
+}
+
+// loginFailed records a failed login to the account with the email from the
+// client.
+func (app *application) loginFailed(r *http.Request, email string) error {
+	reset := time.Now().Add(-loginFailureWindow)
+
+	_, err := app.loginAttempts.Fail(accountKey(email), reset)
+	if err != nil {
+		return err
+	}
+	_, err = app.loginAttempts.Fail(clientKey(r), reset)
+	return err
+}
+
+// waitText describes a wait in whole seconds or, once it is a minute or
+// more, whole minutes, rounding up so that the client doesn't come back too
+// early.
+func waitText(d time.Duration) string {
+	n, unit := int((d+time.Second-1)/time.Second), "second"
+	if d > time.Minute {
+		n, unit = int((d+time.Minute-1)/time.Minute), "minute"
+	}
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func lockedMessage(wait time.Duration) string {
+	return fmt.Sprintf("This account is locked after too many failed login attempts. Please try again in %s.", waitText(wait))
+}
+
+func waitMessage(wait time.Duration) string {
+	return fmt.Sprintf("Too many failed login attempts. Please wait %s before trying again.", waitText(wait))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/TeslaMode1X/snippetbox/pkg/models/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginWait(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		failures int
+		last     time.Time
+		want     time.Duration
+	}{
+		{"No failures", 0, time.Time{}, 0},
+		{"Free failures", 3, now, 0},
+		{"First wait", 4, now, time.Second},
+		{"Doubled", 6, now, 4 * time.Second},
+		{"Partly waited", 6, now.Add(-3 * time.Second), time.Second},
+		{"Fully waited", 6, now.Add(-time.Minute), 0},
+		{"Capped", 40, now, loginBackoffMax},
+		{"Forgotten", 40, now.Add(-2 * loginFailureWindow), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := loginWait(tt.failures, 3, tt.last, now)
+			if got != tt.want {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWaitText(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Second, "1 second"},
+		{1500 * time.Millisecond, "2 seconds"},
+		{time.Minute, "60 seconds"},
+		{14*time.Minute + time.Second, "15 minutes"},
+	}
+
+	for _, tt := range tests {
+		if got := waitText(tt.d); got != tt.want {
+			t.Errorf("waitText(%v): want %q; got %q", tt.d, tt.want, got)
+		}
+	}
+}
+
+// barrierAttempts holds back every check of a client's failures, the last
+// check startLogin makes, until all of the concurrent attempts have made
+// theirs, so that they all find the same counts.
+type barrierAttempts struct {
+	mock.LoginAttemptModel
+	checked sync.WaitGroup
+}
+
+func (m *barrierAttempts) Get(key string) (int, time.Time, error) {
+	failures, last, err := m.LoginAttemptModel.Get(key)
+	if strings.HasPrefix(key, "ip:") {
+		m.checked.Done()
+		m.checked.Wait()
+	}
+	return failures, last, err
+}
+
+func TestStartLoginConcurrently(t *testing.T) {
+	const attempts = 50
+
+	app := newTestApplication(t)
+	store := &barrierAttempts{}
+	store.checked.Add(attempts)
+	app.loginAttempts = store
+
+	// The attempts all find no failures when they check, but no more of
+	// them may go ahead than would have one after another.
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/user/login", nil)
+			locked, wait, err := app.startLogin(r, "alice@example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if locked == 0 && wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != accountFreeFailures+1 {
+		t.Errorf("want %d attempts to go ahead; got %d", accountFreeFailures+1, allowed)
+	}
+	app.loginAttempts = &store.LoginAttemptModel
+	if failures, _, _ := app.loginAttempts.Get("email:alice@example.com"); failures != attempts {
+		t.Errorf("want every attempt counted; got %d", failures)
+	}
+
+	// An attempt whose password turns out to be right is forgiven.
+	r := httptest.NewRequest(http.MethodPost, "/user/login", nil)
+	err := app.forgiveLogin(r, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures, _, _ := app.loginAttempts.Get("email:alice@example.com"); failures != attempts-1 {
+		t.Errorf("want %d failures after forgiving one; got %d", attempts-1, failures)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errInvalidToken is returned for a signed token which is malformed, has
+// expired, or wasn't signed by us for the user it names.
+var errInvalidToken = errors.New("invalid or expired token")
+
+// verifyPurpose is mixed into the signature of email verification tokens, so
+// that they can't be passed off as anything else signed with the same key.
+const verifyPurpose = "verify-email"
+
+// changeEmailPurpose is mixed into the signature of the tokens which confirm
+// a change of email address.
+const changeEmailPurpose = "change-email"
+
+// verificationTTL is how long an email verification link stays valid.
+const verificationTTL = 24 * time.Hour
+
+// signToken returns a token which vouches for a user until it expires. The
+// token is the user ID and expiry time followed by an HMAC of them, the
+// purpose and the address the token is sent to, so a token stops working as
+// soon as that address is changed.
+func (app *application) signToken(purpose string, user *models.User, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", user.ID, expires.Unix())
+	return payload + "." + app.tokenMAC(purpose, payload, tokenEmail(purpose, user))
+}
+
+// tokenEmail returns the address which tokens for the purpose are sent to.
+// Tokens confirming a change of address go to the pending one.
+func tokenEmail(purpose string, user *models.User) string {
+	if purpose == changeEmailPurpose {
+		return user.PendingEmail
+	}
+	return user.Email
+}
+
+// userFromToken checks a token made by signToken for the purpose and returns
+// the user it vouches for.
+func (app *application) userFromToken(purpose, token string) (*models.User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, errInvalidToken
+	}
+
+	user, err := app.users.Get(id)
+	if err == models.ErrNoRecord {
+		return nil, errInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	email := tokenEmail(purpose, user)
+	if email == "" {
+		return nil, errInvalidToken
+	}
+	want := app.tokenMAC(purpose, parts[0]+"."+parts[1], email)
+	if !hmac.Equal([]byte(parts[2]), []byte(want)) {
+		return nil, errInvalidToken
+	}
+
+	return user, nil
+}
+
+func (app *application) tokenMAC(purpose, payload, email string) string {
+	mac := hmac.New(sha256.New, app.secret)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s", purpose, payload, email)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
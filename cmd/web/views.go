@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// viewCounter counts snippet views in memory until they are flushed to the
+// database. A visitor who looks at the same snippet again within the window
+// is only counted once. Views are counted against the day they happened on,
+// so that a batch written after midnight doesn't move them to the next day.
+type viewCounter struct {
+	mu      sync.Mutex
+	window  time.Duration
+	seen    map[viewKey]time.Time
+	pending map[models.ViewDay]int
+}
+
+// viewKey identifies a visitor's views of one snippet.
+type viewKey struct {
+	visitor   string
+	snippetID int
+}
+
+func newViewCounter(window time.Duration) *viewCounter {
+	return &viewCounter{
+		window:  window,
+		seen:    map[viewKey]time.Time{},
+		pending: map[models.ViewDay]int{},
+	}
+}
+
+// Add counts a view of the snippet by the visitor, unless they already
+// viewed it within the window, and reports whether it was counted.
+func (c *viewCounter) Add(visitor string, snippetID int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := viewKey{visitor, snippetID}
+	now := time.Now()
+	if last, ok := c.seen[key]; ok && now.Sub(last) < c.window {
+		return false
+	}
+
+	c.seen[key] = now
+	c.pending[models.ViewDay{SnippetID: snippetID, Day: now.UTC().Truncate(24 * time.Hour)}]++
+	return true
+}
+
+// Take returns the views counted since the last call, by snippet and day,
+// and starts counting afresh. It also forgets the visitors whose window is over,
+// so that the counter doesn't grow forever.
+func (c *viewCounter) Take() map[models.ViewDay]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := time.Now().Add(-c.window)
+	for key, last := range c.seen {
+		if last.Before(cutoff) {
+			delete(c.seen, key)
+		}
+	}
+
+	counts := c.pending
+	c.pending = map[models.ViewDay]int{}
+	return counts
+}
+
+// Restore puts back views which were taken but couldn't be written, so that
+// they are tried again with the next batch.
+func (c *viewCounter) Restore(counts map[models.ViewDay]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, n := range counts {
+		c.pending[key] += n
+	}
+}
+
+// countView counts a view of a snippet by the visitor making the request.
+// Visitors are told apart by a random ID kept in their session, so this must
+// be called before anything is written to the response.
+func (app *application) countView(r *http.Request, snippetID int) {
+	visitor := app.session.GetString(r, "visitor")
+	if visitor == "" {
+		b := make([]byte, 16)
+		_, err := rand.Read(b)
+		if err != nil {
+			app.errorLog.Print(err)
+			return
+		}
+		visitor = base64.RawURLEncoding.EncodeToString(b)
+		app.session.Put(r, "visitor", visitor)
+	}
+
+	app.viewCounter.Add(visitor, snippetID)
+}
+
+// flushViews writes the counted views to the database once every interval,
+// until ctx is cancelled. It flushes one last time before returning, so that
+// views counted just before the server stops aren't lost; ctx should only be
+// cancelled once no more requests are being served.
+func (app *application) flushViews(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			app.flush()
+			return
+		case <-ticker.C:
+			app.flush()
+		}
+	}
+}
+
+// flush writes a single batch of counted views to the database.
+func (app *application) flush() {
+	counts := app.viewCounter.Take()
+	if len(counts) == 0 {
+		return
+	}
+
+	err := app.views.Add(counts)
+	if err != nil {
+		app.errorLog.Printf("views: %s (keeping %d counts for the next batch)", err, len(counts))
+		app.viewCounter.Restore(counts)
+	}
+}
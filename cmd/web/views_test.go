@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"github.com/TeslaMode1X/snippetbox/pkg/models/mock"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bySnippet adds up counted views by snippet ID, whatever their day.
+func bySnippet(counts map[models.ViewDay]int) map[int]int {
+	views := map[int]int{}
+	for key, n := range counts {
+		views[key.SnippetID] += n
+	}
+	return views
+}
+
+func TestViewCounter(t *testing.T) {
+	c := newViewCounter(time.Hour)
+
+	if !c.Add("alice", 1) {
+		t.Errorf("want the first view to be counted")
+	}
+	if c.Add("alice", 1) {
+		t.Errorf("want a repeat view within the window not to be counted")
+	}
+	c.Add("alice", 2)
+	c.Add("bob", 1)
+
+	counts := bySnippet(c.Take())
+	if counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("want 2 views of snippet 1 and 1 of snippet 2; got %v", counts)
+	}
+	if counts := c.Take(); len(counts) != 0 {
+		t.Errorf("want nothing left after taking the counts; got %v", counts)
+	}
+
+	yesterday := models.ViewDay{SnippetID: 1, Day: time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)}
+	c.Restore(map[models.ViewDay]int{yesterday: 2})
+	c.Add("carol", 1)
+	counts = bySnippet(c.Take())
+	if counts[1] != 3 {
+		t.Errorf("want restored views to be taken again; got %v", counts)
+	}
+}
+
+func TestViewCounterDay(t *testing.T) {
+	c := newViewCounter(time.Hour)
+
+	before := time.Now().UTC().Truncate(24 * time.Hour)
+	c.Add("alice", 1)
+	after := time.Now().UTC().Truncate(24 * time.Hour)
+
+	for key := range c.Take() {
+		if !key.Day.Equal(before) && !key.Day.Equal(after) {
+			t.Errorf("want the view counted on the day it happened; got %s", key.Day)
+		}
+	}
+
+	// Views restored from an earlier day stay on that day, rather than
+	// being merged into today's.
+	yesterday := models.ViewDay{SnippetID: 1, Day: before.AddDate(0, 0, -1)}
+	c.Restore(map[models.ViewDay]int{yesterday: 2})
+	c.Add("bob", 1)
+	counts := c.Take()
+	if len(counts) != 2 || counts[yesterday] != 2 {
+		t.Errorf("want yesterday's views kept apart from today's; got %v", counts)
+	}
+}
+
+func TestViewCounterWindow(t *testing.T) {
+	c := newViewCounter(time.Millisecond)
+
+	c.Add("alice", 1)
+	time.Sleep(5 * time.Millisecond)
+	if !c.Add("alice", 1) {
+		t.Errorf("want a repeat view after the window to be counted")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	c.Take()
+	if len(c.seen) != 0 {
+		t.Errorf("want visitors whose window is over to be forgotten")
+	}
+}
+
+// failingViewModel is a view model whose writes always fail.
+type failingViewModel struct {
+	mock.ViewModel
+}
+
+func (m *failingViewModel) Add(counts map[models.ViewDay]int) error {
+	return errors.New("database is down")
+}
+
+func TestFlushKeepsViewsOnError(t *testing.T) {
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+	app.errorLog = log.New(&buf, "", 0)
+	app.views = &failingViewModel{}
+
+	app.viewCounter.Add("alice", 1)
+	app.flush()
+
+	if !strings.Contains(buf.String(), "database is down") {
+		t.Errorf("want the error to be logged; got %q", buf.String())
+	}
+	if counts := bySnippet(app.viewCounter.Take()); counts[1] != 1 {
+		t.Errorf("want the views to be kept for the next batch; got %v", counts)
+	}
+}
+
+func TestFlushViewsFlushesOnStop(t *testing.T) {
+	app := newTestApplication(t)
+	app.viewCounter.Add("alice", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	app.flushViews(ctx, time.Hour)
+
+	if counts := app.viewCounter.Take(); len(counts) != 0 {
+		t.Errorf("want the views to be flushed before stopping; got %v", counts)
+	}
+}
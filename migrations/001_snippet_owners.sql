@@ -0,0 +1,10 @@
+-- Every snippet belongs to the user who created it.
+--
+-- Snippets created before this migration have no owner. Give them one before
+-- the foreign key is added, for example with UPDATE snippets SET user_id = 1,
+-- or the ALTER TABLE which adds it will fail.
+
+ALTER TABLE snippets ADD COLUMN user_id INTEGER NOT NULL AFTER id;
+
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user
+    FOREIGN KEY (user_id) REFERENCES users(id);
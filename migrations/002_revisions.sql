@@ -0,0 +1,17 @@
+-- Every edit of a snippet is kept as a numbered revision. Revision 1 holds
+-- the snippet as it was first created.
+
+CREATE TABLE revisions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    number INTEGER NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT revisions_uc_snippet_number UNIQUE (snippet_id, number),
+    CONSTRAINT fk_revisions_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+-- Existing snippets start their history from what they hold now.
+INSERT INTO revisions (snippet_id, number, title, content, created)
+SELECT id, 1, title, content, created FROM snippets;
@@ -0,0 +1,5 @@
+-- Snippets are public, unlisted or private. Existing snippets were all
+-- listed on the home page, so they stay public.
+
+ALTER TABLE snippets ADD COLUMN visibility ENUM('public', 'unlisted', 'private')
+    NOT NULL DEFAULT 'public' AFTER content;
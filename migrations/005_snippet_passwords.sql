@@ -0,0 +1,4 @@
+-- Protected snippets have the bcrypt hash of their password. The column is
+-- NULL for snippets which anyone allowed to see them can read.
+
+ALTER TABLE snippets ADD COLUMN hashed_password CHAR(60) NULL AFTER burn_after_reading;
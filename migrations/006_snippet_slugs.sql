@@ -0,0 +1,11 @@
+-- Snippets are addressed by a random slug of 64 bits in unpadded, URL-safe
+-- base64. Insert relies on the name of the unique constraint to tell a clash
+-- of slugs from any other duplicate key.
+
+ALTER TABLE snippets ADD COLUMN slug VARCHAR(16) NULL AFTER id;
+
+-- Give existing snippets a slug of the same form as new ones get.
+UPDATE snippets SET slug = REPLACE(REPLACE(TRIM(TRAILING '=' FROM TO_BASE64(RANDOM_BYTES(8))), '+', '-'), '/', '_');
+
+ALTER TABLE snippets MODIFY slug VARCHAR(16) NOT NULL;
+ALTER TABLE snippets ADD CONSTRAINT snippets_uc_slug UNIQUE (slug);
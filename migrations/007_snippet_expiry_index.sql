@@ -0,0 +1,3 @@
+-- The sweeper looks for expired snippets by their expiry time.
+
+CREATE INDEX idx_snippets_expires ON snippets(expires);
@@ -0,0 +1,5 @@
+-- Pages of snippets are listed by creation time, with the ID breaking ties,
+-- and only ever include public snippets. This index lets a page be read
+-- straight from the position its cursor points at.
+
+CREATE INDEX idx_snippets_visibility_created ON snippets(visibility, created, id);
@@ -0,0 +1,4 @@
+-- Search matches titles and content in natural language mode, which needs a
+-- FULLTEXT index on exactly the columns passed to MATCH().
+
+CREATE FULLTEXT INDEX snippets_ft_title_content ON snippets(title, content);
@@ -0,0 +1,3 @@
+-- The language a snippet is highlighted as, or empty for plain text.
+
+ALTER TABLE snippets ADD COLUMN language VARCHAR(20) NOT NULL DEFAULT '' AFTER content;
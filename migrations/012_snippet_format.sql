@@ -0,0 +1,4 @@
+-- Snippets are written either as text or as Markdown. Existing snippets are
+-- all text.
+
+ALTER TABLE snippets ADD COLUMN format ENUM('text', 'markdown') NOT NULL DEFAULT 'text' AFTER content;
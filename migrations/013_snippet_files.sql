@@ -0,0 +1,14 @@
+-- A snippet's main file can have a name, and further files are kept in
+-- snippet_files in the order they were given.
+
+ALTER TABLE snippets ADD COLUMN filename VARCHAR(100) NOT NULL DEFAULT '' AFTER title;
+
+CREATE TABLE snippet_files (
+    snippet_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    language VARCHAR(20) NOT NULL DEFAULT '',
+    content TEXT NOT NULL,
+    PRIMARY KEY (snippet_id, position),
+    CONSTRAINT fk_snippet_files_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
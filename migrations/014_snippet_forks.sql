@@ -0,0 +1,7 @@
+-- A fork points at the snippet it was copied from. The link is cleared when
+-- the original is deleted, so forks outlive it.
+
+ALTER TABLE snippets ADD COLUMN forked_from INTEGER NULL AFTER language;
+
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_forked_from
+    FOREIGN KEY (forked_from) REFERENCES snippets(id);
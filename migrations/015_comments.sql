@@ -0,0 +1,15 @@
+-- Comments on snippets. Line is the line of the snippet's main file which a
+-- comment is about, or 0 for the snippet as a whole.
+
+CREATE TABLE comments (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    line INTEGER NOT NULL DEFAULT 0,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT fk_comments_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id),
+    CONSTRAINT fk_comments_user FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE INDEX idx_comments_snippet_created ON comments(snippet_id, created);
@@ -0,0 +1,15 @@
+-- Stars given to snippets. A user can star a snippet once, which the primary
+-- key enforces and Toggle relies on.
+
+CREATE TABLE stars (
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (snippet_id, user_id),
+    CONSTRAINT fk_stars_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id),
+    CONSTRAINT fk_stars_user FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+-- For listing a user's stars, and for ranking snippets by recent stars.
+CREATE INDEX idx_stars_user_created ON stars(user_id, created);
+CREATE INDEX idx_stars_created ON stars(created);
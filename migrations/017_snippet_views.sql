@@ -0,0 +1,10 @@
+-- Daily view counts. Batches of views are added to the row for their
+-- snippet and day, which relies on this primary key.
+
+CREATE TABLE snippet_views (
+    snippet_id INTEGER NOT NULL,
+    day DATE NOT NULL,
+    views INTEGER NOT NULL,
+    PRIMARY KEY (snippet_id, day),
+    CONSTRAINT fk_snippet_views_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,6 @@
+-- Users verify their email address before they can log in. Accounts which
+-- existed before verification was required are treated as verified.
+
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE AFTER hashed_password;
+
+UPDATE users SET verified = TRUE;
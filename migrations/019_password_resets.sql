@@ -0,0 +1,12 @@
+-- Password reset tokens, stored as SHA-256 hashes. The session version goes
+-- up whenever a user's existing sessions should be logged out, such as when
+-- their password is reset.
+
+ALTER TABLE users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0 AFTER verified;
+
+CREATE TABLE password_resets (
+    token_hash BINARY(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT fk_password_resets_user FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE = InnoDB;
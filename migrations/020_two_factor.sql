@@ -0,0 +1,13 @@
+-- Two-factor authentication. totp_secret is NULL for users who haven't turned
+-- it on, and totp_last_step is the last time step a code was accepted for, so
+-- that no code works twice. Recovery codes are stored as SHA-256 hashes.
+
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NULL AFTER session_version;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0 AFTER totp_secret;
+
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    code_hash BINARY(32) NOT NULL,
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE = InnoDB;
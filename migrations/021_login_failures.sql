@@ -0,0 +1,9 @@
+-- Failed logins, counted per key: "email:" followed by the address which was
+-- tried, or "ip:" followed by the client's address. Rows are replaced when a
+-- failure comes after the old ones have been forgotten.
+
+CREATE TABLE login_failures (
+    key_name VARCHAR(300) NOT NULL PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure DATETIME NOT NULL
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
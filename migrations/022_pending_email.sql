@@ -0,0 +1,4 @@
+-- A new email address stays pending until the user follows the link sent to
+-- it; until then they keep logging in with their current address.
+
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255) NULL AFTER email;
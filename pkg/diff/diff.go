@@ -0,0 +1,282 @@
+// Package diff computes line based differences between two texts and groups
+// them into hunks in the style of the unified diff format.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op describes what happened to a line when going from the old text to the
+// new text.
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Line is a single line of a diff. OldNumber and NewNumber are the 1-based
+// line numbers in the old and new text; a line which only exists on one side
+// has a zero number on the other.
+type Line struct {
+	Op        Op
+	Text      string
+	OldNumber int
+	NewNumber int
+}
+
+// Prefix returns the marker used for the line in a unified diff.
+func (l Line) Prefix() string {
+	switch l.Op {
+	case Insert:
+		return "+"
+	case Delete:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+func (l Line) String() string {
+	return l.Prefix() + l.Text
+}
+
+// Hunk is a group of changed lines together with the unchanged lines which
+// surround them.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// Header returns the "@@ -a,b +c,d @@" line which introduces the hunk.
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Lines returns every line of a and b in order, marking each one as equal,
+// inserted or deleted. The result describes the smallest number of inserted
+// and deleted lines which turn a into b.
+//
+// The lines are compared with the linear space refinement of Myers' O(ND)
+// algorithm, so the memory used only grows with the length of the texts,
+// though the time taken still grows with their length times the number of
+// differences between them.
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+
+	d := &differ{
+		x:        x,
+		y:        y,
+		deleted:  make([]bool, len(x)),
+		inserted: make([]bool, len(y)),
+	}
+	// Both searches of a split look at diagonals -D-1 to D+1, where D is at
+	// most half the number of lines.
+	size := len(x) + len(y) + 3
+	d.forward, d.backward = make([]int, size), make([]int, size)
+	d.compare(0, len(x), 0, len(y))
+
+	// Within each run of changes the deleted lines come before the
+	// inserted ones, as they do in a unified diff.
+	lines := make([]Line, 0, len(x)+len(y))
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && d.deleted[i]:
+			lines = append(lines, Line{Op: Delete, Text: x[i], OldNumber: i + 1})
+			i++
+		case j < len(y) && d.inserted[j]:
+			lines = append(lines, Line{Op: Insert, Text: y[j], NewNumber: j + 1})
+			j++
+		default:
+			lines = append(lines, Line{Op: Equal, Text: x[i], OldNumber: i + 1, NewNumber: j + 1})
+			i++
+			j++
+		}
+	}
+
+	return lines
+}
+
+// differ holds the state of a comparison between the lines x and y. Lines
+// which aren't part of the longest common subsequence are marked in deleted
+// and inserted. forward and backward are the furthest reaching paths of the
+// two searches in split, kept here so that every split can reuse them.
+type differ struct {
+	x, y              []string
+	deleted, inserted []bool
+	forward, backward []int
+}
+
+// compare marks the lines which differ between x[xlo:xhi] and y[ylo:yhi].
+func (d *differ) compare(xlo, xhi, ylo, yhi int) {
+	// Lines shared at the start and the end are common in edits and need no
+	// work, so strip them first.
+	for xlo < xhi && ylo < yhi && d.x[xlo] == d.y[ylo] {
+		xlo++
+		ylo++
+	}
+	for xlo < xhi && ylo < yhi && d.x[xhi-1] == d.y[yhi-1] {
+		xhi--
+		yhi--
+	}
+
+	switch {
+	case xlo == xhi:
+		for j := ylo; j < yhi; j++ {
+			d.inserted[j] = true
+		}
+	case ylo == yhi:
+		for i := xlo; i < xhi; i++ {
+			d.deleted[i] = true
+		}
+	default:
+		// Neither side is empty and they differ at both ends, so there are
+		// at least two differences and the middle snake splits the ranges
+		// into two smaller ones.
+		x0, y0, x1, y1 := d.split(xlo, xhi, ylo, yhi)
+		d.compare(xlo, x0, ylo, y0)
+		d.compare(x1, xhi, y1, yhi)
+	}
+}
+
+// split finds the middle snake of a shortest edit script between
+// x[xlo:xhi] and y[ylo:yhi]: a run of equal lines, from (x0, y0) to
+// (x1, y1), which lies halfway along the script. It searches forwards from
+// the start and backwards from the end at the same time until the two meet.
+//
+// Diagonal k holds the points where x - y = k, relative to the start for
+// the forward search and to the end for the backward one. forward[k] is the
+// furthest x reached on diagonal k going forwards, and backward[k] the
+// furthest distance from the end reached on it going backwards.
+func (d *differ) split(xlo, xhi, ylo, yhi int) (x0, y0, x1, y1 int) {
+	n, m := xhi-xlo, yhi-ylo
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	off := limit + 1
+
+	fwd, bwd := d.forward, d.backward
+	fwd[off+1], bwd[off+1] = 0, 0
+
+	for D := 0; D <= limit; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.x[xlo+x] == d.y[ylo+y] {
+				x++
+				y++
+			}
+			fwd[off+k] = x
+
+			// The backward search has taken D-1 steps, and its diagonal
+			// delta-k is the same line as diagonal k.
+			if rk := delta - k; odd && rk >= -(D-1) && rk <= D-1 && x+bwd[off+rk] >= n {
+				return xlo + sx, ylo + sy, xlo + x, ylo + y
+			}
+		}
+
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && bwd[off+k-1] < bwd[off+k+1]) {
+				x = bwd[off+k+1]
+			} else {
+				x = bwd[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.x[xhi-1-x] == d.y[yhi-1-y] {
+				x++
+				y++
+			}
+			bwd[off+k] = x
+
+			if fk := delta - k; !odd && fk >= -D && fk <= D && x+fwd[off+fk] >= n {
+				return xhi - x, yhi - y, xhi - sx, yhi - sy
+			}
+		}
+	}
+
+	// The searches always meet by the time D reaches limit.
+	panic("diff: no middle snake found")
+}
+
+// Unified returns the hunks of a unified diff between a and b, keeping up to
+// context unchanged lines around every change. Identical texts produce no
+// hunks.
+func Unified(a, b string, context int) []Hunk {
+	lines := Lines(a, b)
+
+	hunks := []Hunk{}
+	var h *Hunk
+	// lastChange is the index of the most recent inserted or deleted line
+	// which belongs to the current hunk.
+	lastChange := -1
+
+	for i, l := range lines {
+		if l.Op == Equal {
+			continue
+		}
+
+		start := max(i-context, 0)
+		if h != nil && start <= lastChange+context+1 {
+			// Close enough to the previous change to share its hunk.
+			start = lastChange + 1
+		} else {
+			if h != nil {
+				h.add(lines[lastChange+1 : min(lastChange+1+context, i)])
+				hunks = append(hunks, *h)
+			}
+			h = &Hunk{}
+		}
+		h.add(lines[start : i+1])
+		lastChange = i
+	}
+
+	if h != nil {
+		h.add(lines[lastChange+1 : min(lastChange+1+context, len(lines))])
+		hunks = append(hunks, *h)
+	}
+
+	return hunks
+}
+
+// add appends lines to the hunk and updates its line ranges.
+func (h *Hunk) add(lines []Line) {
+	for _, l := range lines {
+		if l.OldNumber != 0 {
+			if h.OldStart == 0 {
+				h.OldStart = l.OldNumber
+			}
+			h.OldLines++
+		}
+		if l.NewNumber != 0 {
+			if h.NewStart == 0 {
+				h.NewStart = l.NewNumber
+			}
+			h.NewLines++
+		}
+		h.Lines = append(h.Lines, l)
+	}
+}
+
+// split breaks s into lines. Windows line endings are treated the same as
+// Unix ones and a trailing newline does not start an extra, empty line.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,217 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// render turns lines back into the unified diff body, one line per entry.
+func render(lines []Line) []string {
+	out := []string{}
+	for _, l := range lines {
+		out = append(out, l.String())
+	}
+	return out
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []string
+	}{
+		{
+			name: "Identical",
+			a:    "one\ntwo\n",
+			b:    "one\ntwo\n",
+			want: []string{" one", " two"},
+		},
+		{
+			name: "Both empty",
+			a:    "",
+			b:    "",
+			want: []string{},
+		},
+		{
+			name: "From empty",
+			a:    "",
+			b:    "one\ntwo",
+			want: []string{"+one", "+two"},
+		},
+		{
+			name: "To empty",
+			a:    "one\ntwo",
+			b:    "",
+			want: []string{"-one", "-two"},
+		},
+		{
+			name: "Changed line",
+			a:    "one\ntwo\nthree",
+			b:    "one\n2\nthree",
+			want: []string{" one", "-two", "+2", " three"},
+		},
+		{
+			name: "Inserted and deleted lines",
+			a:    "a\nb\nc\nd",
+			b:    "b\nc\nx\nd",
+			want: []string{"-a", " b", " c", "+x", " d"},
+		},
+		{
+			name: "Windows line endings",
+			a:    "one\r\ntwo\r\n",
+			b:    "one\ntwo\n",
+			want: []string{" one", " two"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(Lines(tt.a, tt.b))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	lines := Lines("a\nb\nc", "a\nx\nc")
+
+	want := []Line{
+		{Op: Equal, Text: "a", OldNumber: 1, NewNumber: 1},
+		{Op: Delete, Text: "b", OldNumber: 2},
+		{Op: Insert, Text: "x", NewNumber: 2},
+		{Op: Equal, Text: "c", OldNumber: 3, NewNumber: 3},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("want %+v; got %+v", want, lines)
+	}
+}
+
+// lcsLength returns the length of the longest common subsequence of x and y
+// the slow way, to check Lines against.
+func lcsLength(x, y []string) int {
+	prev, cur := make([]int, len(y)+1), make([]int, len(y)+1)
+	for i := range x {
+		for j := range y {
+			if x[i] == y[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(y)]
+}
+
+// check fails the test unless lines turn a into b with the fewest changes.
+func check(t *testing.T, a, b string, lines []Line) {
+	t.Helper()
+
+	var oldLines, newLines []string
+	changes := 0
+	for _, l := range lines {
+		if l.Op != Insert {
+			oldLines = append(oldLines, l.Text)
+		}
+		if l.Op != Delete {
+			newLines = append(newLines, l.Text)
+		}
+		if l.Op != Equal {
+			changes++
+		}
+	}
+	x, y := split(a), split(b)
+	if !reflect.DeepEqual(oldLines, x) || !reflect.DeepEqual(newLines, y) {
+		t.Fatalf("want the lines to turn %q into %q; got %q", a, b, render(lines))
+	}
+	if want := len(x) + len(y) - 2*lcsLength(x, y); changes != want {
+		t.Errorf("want %d changes from %q to %q; got %d", want, a, b, changes)
+	}
+}
+
+func TestLinesRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	text := func() string {
+		lines := make([]string, rnd.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + rnd.Intn(4)))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := text(), text()
+		check(t, a, b, Lines(a, b))
+	}
+}
+
+func TestLinesLarge(t *testing.T) {
+	// Fifty thousand lines on each side would need a table of ten gigabytes
+	// with a quadratic algorithm.
+	old := make([]string, 50000)
+	for i := range old {
+		old[i] = fmt.Sprintf("line %d", i)
+	}
+	changed := append([]string{}, old...)
+	for i := 0; i < len(changed); i += 5000 {
+		changed[i] = "changed"
+	}
+	a, b := strings.Join(old, "\n"), strings.Join(changed, "\n")
+
+	lines := Lines(a, b)
+	if len(lines) != 50010 {
+		t.Errorf("want 50010 lines; got %d", len(lines))
+	}
+	if hunks := Unified(a, b, 3); len(hunks) != 10 {
+		t.Errorf("want 10 hunks; got %d", len(hunks))
+	}
+}
+
+func TestUnified(t *testing.T) {
+	// Twenty numbered lines with changes to lines 2 and 18, which are far
+	// enough apart to end up in separate hunks.
+	old := []string{}
+	for i := 1; i <= 20; i++ {
+		old = append(old, strings.Repeat("x", i))
+	}
+	changed := append([]string{}, old...)
+	changed[1] = "two"
+	changed[17] = "eighteen"
+
+	hunks := Unified(strings.Join(old, "\n"), strings.Join(changed, "\n"), 3)
+	if len(hunks) != 2 {
+		t.Fatalf("want 2 hunks; got %d", len(hunks))
+	}
+
+	tests := []struct {
+		hunk   Hunk
+		header string
+		size   int
+	}{
+		{hunks[0], "@@ -1,5 +1,5 @@", 6},
+		{hunks[1], "@@ -15,6 +15,6 @@", 7},
+	}
+	for _, tt := range tests {
+		if h := tt.hunk.Header(); h != tt.header {
+			t.Errorf("want header %q; got %q", tt.header, h)
+		}
+		if len(tt.hunk.Lines) != tt.size {
+			t.Errorf("want %d lines; got %d", tt.size, len(tt.hunk.Lines))
+		}
+	}
+
+	// With a larger context both changes share a single hunk.
+	if hunks := Unified(strings.Join(old, "\n"), strings.Join(changed, "\n"), 10); len(hunks) != 1 {
+		t.Errorf("want 1 hunk; got %d", len(hunks))
+	}
+
+	if hunks := Unified("same", "same", 3); len(hunks) != 0 {
+		t.Errorf("want no hunks for identical input; got %d", len(hunks))
+	}
+}
@@ -8,6 +8,14 @@ import (
 	"unicode/utf8"
 )
 
+// TagRX matches a single tag: lowercase letters, digits and hyphens, starting
+// with a letter or digit.
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// FilenameRX matches a file name: letters, digits, dots, hyphens and
+// underscores, not starting with a dot.
+var FilenameRX = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
 var EmailRX = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
 
 // Form represents a form with validation errors.
@@ -83,3 +91,39 @@ func (f *Form) PermittedValues(field string, opts ...string) {
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0 // Return true if there are no errors.
 }
+
+// Tags splits a comma-separated list of tags into lowercase tags, dropping
+// blanks and duplicates.
+func Tags(value string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// ValidTags checks that the specified field holds no more than max tags,
+// each no longer than maxLength characters and matching TagRX.
+func (f *Form) ValidTags(field string, max, maxLength int) {
+	tags := Tags(f.Get(field))
+	if len(tags) > max {
+		f.Errors.Add(field, fmt.Sprintf("Too many tags (maximum is %d)", max))
+		return
+	}
+	for _, tag := range tags {
+		if utf8.RuneCountInString(tag) > maxLength {
+			f.Errors.Add(field, fmt.Sprintf("The tag %q is too long (maximum is %d characters)", tag, maxLength))
+			return
+		}
+		if !TagRX.MatchString(tag) {
+			f.Errors.Add(field, fmt.Sprintf("The tag %q may only contain letters, digits and hyphens", tag))
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package highlight turns snippet content into syntax highlighted HTML.
+package highlight
+
+import (
+	"bytes"
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// Language is a language which snippets can be highlighted as. ID is what is
+// stored with a snippet, and is also the name chroma knows the language by.
+// Extension is used for the names of downloaded files.
+type Language struct {
+	ID        string
+	Name      string
+	Extension string
+}
+
+// Languages lists the languages authors can pick from, in the order they are
+// offered.
+var Languages = []Language{
+	{"bash", "Bash", ".sh"},
+	{"c", "C", ".c"},
+	{"cpp", "C++", ".cpp"},
+	{"csharp", "C#", ".cs"},
+	{"css", "CSS", ".css"},
+	{"diff", "Diff", ".diff"},
+	{"docker", "Dockerfile", ".dockerfile"},
+	{"go", "Go", ".go"},
+	{"html", "HTML", ".html"},
+	{"java", "Java", ".java"},
+	{"javascript", "JavaScript", ".js"},
+	{"json", "JSON", ".json"},
+	{"kotlin", "Kotlin", ".kt"},
+	{"php", "PHP", ".php"},
+	{"python", "Python", ".py"},
+	{"ruby", "Ruby", ".rb"},
+	{"rust", "Rust", ".rs"},
+	{"sql", "SQL", ".sql"},
+	{"typescript", "TypeScript", ".ts"},
+	{"yaml", "YAML", ".yaml"},
+}
+
+// formatter writes tokens as <span> elements with inline styles, leaving it
+// to the page to wrap them in a <pre> element. Every piece of text is HTML
+// escaped by the formatter.
+var formatter = html.New(html.PreventSurroundingPre(true), html.TabWidth(4))
+
+// numberedFormatter writes a table with the line numbers in one column and
+// the code in the other, each in a <pre> element of its own so that copying
+// the code doesn't copy the numbers too. Line n has the ID "Ln", and its
+// number links to it.
+var numberedFormatter = html.New(html.WithLineNumbers(true), html.LineNumbersInTable(true),
+	html.WithLinkableLineNumbers(true, "L"), html.TabWidth(4))
+
+var style = styles.Get("github")
+
+// Name returns the display name of a language, or the empty string if it
+// isn't one of Languages.
+func Name(id string) string {
+	for _, l := range Languages {
+		if l.ID == id {
+			return l.Name
+		}
+	}
+	return ""
+}
+
+// Extension returns the file extension for a language, or ".txt" if it isn't
+// one of Languages.
+func Extension(id string) string {
+	for _, l := range Languages {
+		if l.ID == id {
+			return l.Extension
+		}
+	}
+	return ".txt"
+}
+
+// Detect guesses which of Languages the content is written in. It returns
+// the empty string if it can't tell.
+func Detect(content string) string {
+	found := lexers.Analyse(content)
+	if found == nil {
+		return ""
+	}
+
+	for _, l := range Languages {
+		if lexers.Get(l.ID) == found {
+			return l.ID
+		}
+	}
+	return ""
+}
+
+// HTML returns the content highlighted as the given language. Content in a
+// language chroma doesn't know is returned escaped but otherwise unchanged.
+func HTML(content, language string) (string, error) {
+	return format(formatter, content, language)
+}
+
+// NumberedHTML works like HTML, but numbers the lines of the content.
+func NumberedHTML(content, language string) (string, error) {
+	return format(numberedFormatter, content, language)
+}
+
+func format(f *html.Formatter, content, language string) (string, error) {
+	lexer := lexers.Get(language)
+	if lexer == nil || language == "" {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = f.Format(&buf, style, iterator)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
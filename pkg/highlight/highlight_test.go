@@ -0,0 +1,83 @@
+package highlight
+
+import (
+	"github.com/alecthomas/chroma/v2/lexers"
+	"strings"
+	"testing"
+)
+
+func TestLanguagesAreKnown(t *testing.T) {
+	for _, l := range Languages {
+		if lexers.Get(l.ID) == nil {
+			t.Errorf("chroma has no lexer for %q", l.ID)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"Shebang", "#!/bin/bash\necho hello\n", "bash"},
+		{"Go", "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(1)\n}\n", "go"},
+		{"C", "#include <stdio.h>\nint main() { return 0; }\n", "c"},
+		{"Prose", "An old silent pond...", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Detect(tt.content)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		language string
+		want     string
+		dontWant string
+	}{
+		{"Keyword", "package main", "go", "<span style=", ""},
+		{"No surrounding pre", "package main", "go", "package", "<pre"},
+		{"Escaped", "x := \"<script>\"", "go", "&lt;script&gt;", "<script>"},
+		{"Plain text", "<b>bold</b>", "", "&lt;b&gt;bold&lt;/b&gt;", "<b>"},
+		{"Unknown language", "<b>bold</b>", "klingon", "&lt;b&gt;", "<b>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HTML(tt.content, tt.language)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("want %q to contain %q", got, tt.want)
+			}
+			if tt.dontWant != "" && strings.Contains(got, tt.dontWant) {
+				t.Errorf("want %q not to contain %q", got, tt.dontWant)
+			}
+		})
+	}
+}
+
+func TestNumberedHTML(t *testing.T) {
+	got, err := NumberedHTML("package main\n\nfunc main() {}\n", "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`id="L1"`, `href="#L3"`, "<table"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, `id="L4"`) {
+		t.Errorf("want no number for the line after the final newline")
+	}
+}
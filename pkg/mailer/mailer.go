@@ -0,0 +1,99 @@
+// Package mailer sends the emails snippetbox needs, such as the links which
+// verify users' addresses.
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidHeader is returned when an address or subject contains a line
+// break, which would let it add headers of its own to the message.
+var ErrInvalidHeader = errors.New("mailer: line break in header")
+
+// Mailer sends a plain text email.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTP sends emails through an SMTP server. The connection is upgraded with
+// STARTTLS when the server supports it, and if Username is set the mailer
+// logs in with it and Password.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send sends an email through the SMTP server.
+func (m *SMTP) Send(to, subject, body string) error {
+	msg, err := message(m.From, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, address(m.From), []string{to}, msg)
+}
+
+// Log writes emails to a logger instead of sending them, for development and
+// tests. Pointing the logger at a file keeps a record of everything "sent".
+type Log struct {
+	Logger *log.Logger
+	From   string
+}
+
+// Send writes the email to the logger.
+func (m *Log) Send(to, subject, body string) error {
+	msg, err := message(m.From, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	m.Logger.Printf("mailer: email to %s\n%s", to, msg)
+	return nil
+}
+
+// message builds an email with the given headers and plain text body.
+func message(from, to, subject, body string) ([]byte, error) {
+	for _, h := range []string{from, to, subject} {
+		if strings.ContainsAny(h, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// address returns the bare address from an address such as
+// "Snippetbox <no-reply@example.com>", for the SMTP envelope.
+func address(s string) string {
+	if i := strings.LastIndex(s, "<"); i >= 0 {
+		return strings.TrimSuffix(s[i+1:], ">")
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Log{Logger: log.New(&buf, "", 0), From: "Snippetbox <no-reply@example.com>"}
+
+	err := m.Send("alice@example.com", "Hello", "First line\nSecond line")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{
+		"From: Snippetbox <no-reply@example.com>\r\n",
+		"To: alice@example.com\r\n",
+		"Subject: Hello\r\n",
+		"\r\n\r\nFirst line\r\nSecond line",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q to contain %q", got, want)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	m := &Log{Logger: log.New(&bytes.Buffer{}, "", 0), From: "no-reply@example.com"}
+
+	tests := []struct {
+		name    string
+		to      string
+		subject string
+	}{
+		{"Address", "alice@example.com\r\nBcc: eve@example.com", "Hello"},
+		{"Subject", "alice@example.com", "Hello\nBcc: eve@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Send(tt.to, tt.subject, "Body")
+			if err != ErrInvalidHeader {
+				t.Errorf("want ErrInvalidHeader; got %v", err)
+			}
+		})
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"Snippetbox <no-reply@example.com>", "no-reply@example.com"},
+		{"no-reply@example.com", "no-reply@example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := address(tt.s); got != tt.want {
+			t.Errorf("address(%q): want %q; got %q", tt.s, tt.want, got)
+		}
+	}
+}
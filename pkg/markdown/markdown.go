@@ -0,0 +1,61 @@
+// Package markdown turns Markdown snippets into HTML which is safe to show to
+// other users.
+package markdown
+
+import (
+	"bytes"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"regexp"
+	"strings"
+)
+
+// converter understands GitHub Flavored Markdown, so runbooks can use
+// tables, task lists and fenced code blocks. Raw HTML in the source is
+// dropped by goldmark unless it is told otherwise.
+var converter = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// policy is applied to the HTML which goldmark produces. It allows the
+// elements and attributes users are expected to write, and strips everything
+// else: scripts, styles, event handler attributes and links with schemes
+// other than http, https and mailto, such as javascript: URLs. Links also get
+// rel="nofollow noopener" so they can't be used to game search rankings.
+//
+// The only inputs allowed are the checkboxes of task lists. Inputs of any
+// other type lose their type attribute, and are then removed by sanitize.
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(false)
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}()
+
+// inputTag matches an <input> element in the sanitized HTML. The sanitizer
+// escapes attribute values, so none of them contains a ">".
+var inputTag = regexp.MustCompile(`<input\b[^>]*>`)
+
+// HTML converts Markdown into sanitized HTML.
+func HTML(source string) (string, error) {
+	var buf bytes.Buffer
+	err := converter.Convert([]byte(source), &buf)
+	if err != nil {
+		return "", err
+	}
+
+	return sanitize(buf.String()), nil
+}
+
+// sanitize applies the policy to the HTML. An input without a type is a text
+// box, so only the checkboxes which kept their type attribute are left in.
+func sanitize(html string) string {
+	return inputTag.ReplaceAllStringFunc(policy.Sanitize(html), func(tag string) string {
+		if strings.Contains(tag, ` type="checkbox"`) {
+			return tag
+		}
+		return ""
+	})
+}
@@ -0,0 +1,73 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     string
+		dontWant string
+	}{
+		{"Heading", "# Runbook", "<h1", ""},
+		{"Table", "| a | b |\n|---|---|\n| 1 | 2 |", "<table>", ""},
+		{"Fenced code", "```\nmake deploy\n```", "<pre><code>make deploy", ""},
+		{"Link", "[docs](https://example.com)", `href="https://example.com"`, ""},
+		{"Script tag", "<script>alert(1)</script>", "", "<script"},
+		{"Event handler", `<img src="x.png" onerror="alert(1)">`, "", "onerror"},
+		{"Javascript link", "[click](javascript:alert(1))", "click", "javascript:"},
+		{"Javascript autolink", "<javascript:alert(1)>", "", "href=\"javascript:"},
+		{"Escaped text", "1 < 2 & 3", "1 &lt; 2 &amp; 3", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HTML(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("want %q to contain %q", got, tt.want)
+			}
+			if tt.dontWant != "" && strings.Contains(got, tt.dontWant) {
+				t.Errorf("want %q not to contain %q", got, tt.dontWant)
+			}
+		})
+	}
+}
+
+func TestInputs(t *testing.T) {
+	// goldmark drops raw HTML, so these can't come from a snippet's source
+	// today. They are sanitized as though they could.
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"Checkbox", `<input checked="" disabled="" type="checkbox">`, `<input checked="" disabled="" type="checkbox">`},
+		{"Text input", `<input type="text">`, ""},
+		{"Checked text input", `<input type="text" checked>`, ""},
+		{"Input without a type", `<input disabled>`, ""},
+		{"Button", `<input type="submit" value="Log in">`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitize(tt.html)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+
+	got, err := HTML("- [x] done\n- [ ] not yet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(got, `type="checkbox"`) != 2 {
+		t.Errorf("want two task list checkboxes in %q", got)
+	}
+}
@@ -0,0 +1,97 @@
+// Package memory holds models which keep their data in the memory of the
+// running process, rather than in the database. Their data is lost when the
+// process stops, and isn't shared between several instances of the server.
+package memory
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Fail goes through every key to drop those whose
+// failures have been forgotten, so that the map doesn't grow forever.
+const sweepInterval = time.Minute
+
+// LoginAttemptModel counts failed logins in memory, one entry per key, such
+// as an account's email address or a client's IP address. It can stand in
+// for the MySQL model when the server runs as a single instance. The zero
+// value is ready to use.
+type LoginAttemptModel struct {
+	mu        sync.Mutex
+	failures  map[string]*failures
+	lastSweep time.Time
+}
+
+type failures struct {
+	count int
+	last  time.Time
+}
+
+// Get returns the number of failed logins for the key, along with the time
+// of the last one.
+func (m *LoginAttemptModel) Get(key string) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.failures[key]
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+	return f.count, f.last, nil
+}
+
+// Fail records a failed login for the key and returns how many there are
+// now. If the last failure was before reset, counting starts again from one.
+func (m *LoginAttemptModel) Fail(key string, reset time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.failures == nil {
+		m.failures = map[string]*failures{}
+	}
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(reset)
+		m.lastSweep = now
+	}
+
+	f, ok := m.failures[key]
+	if !ok || f.last.Before(reset) {
+		f = &failures{}
+		m.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	return f.count, nil
+}
+
+// Forgive takes back one failed login for the key, for an attempt which was
+// counted as failed before it turned out to be right.
+func (m *LoginAttemptModel) Forgive(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.failures[key]; ok && f.count > 0 {
+		f.count--
+	}
+	return nil
+}
+
+// Clear forgets the failed logins for the key.
+func (m *LoginAttemptModel) Clear(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, key)
+	return nil
+}
+
+// sweep drops the keys whose last failure was before reset. The caller must
+// hold m.mu.
+func (m *LoginAttemptModel) sweep(reset time.Time) {
+	for key, f := range m.failures {
+		if f.last.Before(reset) {
+			delete(m.failures, key)
+		}
+	}
+}
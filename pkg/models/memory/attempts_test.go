@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptModel(t *testing.T) {
+	var m LoginAttemptModel
+	longAgo := time.Now().Add(-time.Hour)
+
+	for i := 1; i <= 3; i++ {
+		if n, _ := m.Fail("email:alice@example.com", longAgo); n != i {
+			t.Errorf("want Fail to return %d; got %d", i, n)
+		}
+	}
+	m.Fail("ip:192.0.2.1", longAgo)
+
+	if n, last, _ := m.Get("email:alice@example.com"); n != 3 || time.Since(last) > time.Minute {
+		t.Errorf("want 3 recent failures; got %d at %v", n, last)
+	}
+	if n, _, _ := m.Get("email:bob@example.com"); n != 0 {
+		t.Errorf("want no failures for another key; got %d", n)
+	}
+
+	m.Forgive("email:alice@example.com")
+	if n, _, _ := m.Get("email:alice@example.com"); n != 2 {
+		t.Errorf("want 2 failures after forgiving one; got %d", n)
+	}
+	m.Forgive("email:bob@example.com")
+	if n, _, _ := m.Get("email:bob@example.com"); n != 0 {
+		t.Errorf("want forgiving a key without failures to do nothing; got %d", n)
+	}
+
+	// Failures from before the reset time don't count any more.
+	m.Fail("email:alice@example.com", time.Now().Add(time.Second))
+	if n, _, _ := m.Get("email:alice@example.com"); n != 1 {
+		t.Errorf("want counting to start again; got %d", n)
+	}
+
+	m.Clear("email:alice@example.com")
+	if n, _, _ := m.Get("email:alice@example.com"); n != 0 {
+		t.Errorf("want no failures after clearing; got %d", n)
+	}
+}
+
+func TestLoginAttemptModelSweep(t *testing.T) {
+	var m LoginAttemptModel
+	m.Fail("ip:192.0.2.1", time.Now().Add(-time.Hour))
+
+	// Once the sweep interval has passed, a failure for any key drops the
+	// keys whose failures have been forgotten.
+	m.lastSweep = time.Now().Add(-2 * sweepInterval)
+	m.Fail("ip:192.0.2.2", time.Now().Add(time.Second))
+
+	if _, ok := m.failures["ip:192.0.2.1"]; ok {
+		t.Errorf("want the forgotten key to be swept")
+	}
+	if n, _, _ := m.Get("ip:192.0.2.2"); n != 1 {
+		t.Errorf("want the new failure to be counted; got %d", n)
+	}
+}
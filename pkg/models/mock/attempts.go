@@ -0,0 +1,12 @@
+package mock
+
+import (
+	"github.com/TeslaMode1X/snippetbox/pkg/models/memory"
+)
+
+// LoginAttemptModel is the in-memory model. Unlike the other mocks it does
+// keep state, so that tests can simulate attacks by failing repeatedly. The
+// zero value is ready to use.
+type LoginAttemptModel struct {
+	memory.LoginAttemptModel
+}
@@ -0,0 +1,57 @@
+package mock
+
+import (
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"time"
+)
+
+// mockComments are the comments on mockSnippet. The first was left by the
+// mock user and the second by another user, about the first line.
+var mockComments = []*models.Comment{
+	{
+		ID:        1,
+		SnippetID: 1,
+		UserID:    1,
+		UserName:  "Alice",
+		Content:   "Written at *dusk*.",
+		Created:   time.Now(),
+	},
+	{
+		ID:        2,
+		SnippetID: 1,
+		UserID:    2,
+		UserName:  "Bob",
+		Line:      1,
+		Content:   "Lovely <script>alert(1)</script> line.",
+		Created:   time.Now(),
+	},
+}
+
+type CommentModel struct{}
+
+func (m *CommentModel) Insert(snippetID, userID, line int, content string) (int, error) {
+	return 3, nil
+}
+
+func (m *CommentModel) Get(id int) (*models.Comment, error) {
+	for _, c := range mockComments {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *CommentModel) ForSnippet(snippetID int) ([]*models.Comment, error) {
+	comments := []*models.Comment{}
+	for _, c := range mockComments {
+		if c.SnippetID == snippetID {
+			comments = append(comments, c)
+		}
+	}
+	return comments, nil
+}
+
+func (m *CommentModel) Delete(id int) error {
+	return nil
+}
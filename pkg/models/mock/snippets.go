@@ -1,33 +1,386 @@
 package mock
 
 import (
+	"fmt"
 	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"slices"
+	"strings"
 	"time"
 )
 
 var mockSnippet = &models.Snippet{
-	ID:      1,
-	Title:   "An old silent pond",
-	Content: "An old silent pond...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:         1,
+	Slug:       "pond",
+	UserID:     1,
+	UserName:   "Alice",
+	Title:      "An old silent pond",
+	Content:    "An old silent pond...",
+	Visibility: models.VisibilityPublic,
+	Tags:       []string{"haiku", "water"},
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockForeignSnippet belongs to a user other than the mock user, so it can be
+// used to check that only owners are allowed to change a snippet.
+var mockForeignSnippet = &models.Snippet{
+	ID:         3,
+	Slug:       "forest",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "Over the wintry forest",
+	Content:    "Over the wintry forest, winds howl in rage...",
+	Visibility: models.VisibilityPublic,
+	Tags:       []string{"haiku"},
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockPrivateSnippet is a private snippet which belongs to another user.
+var mockPrivateSnippet = &models.Snippet{
+	ID:         4,
+	Slug:       "autumn",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "First autumn morning",
+	Content:    "First autumn morning: the mirror I stare into...",
+	Visibility: models.VisibilityPrivate,
+	Tags:       []string{"haiku"},
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockUnlistedSnippet is an unlisted snippet which belongs to another user.
+var mockUnlistedSnippet = &models.Snippet{
+	ID:         5,
+	Slug:       "dew",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "A world of dew",
+	Content:    "A world of dew, and within every dewdrop...",
+	Visibility: models.VisibilityUnlisted,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockOwnPrivateSnippet is a private snippet which belongs to the mock user.
+var mockOwnPrivateSnippet = &models.Snippet{
+	ID:         6,
+	Slug:       "moon",
+	UserID:     1,
+	UserName:   "Alice",
+	Title:      "Light of the moon",
+	Content:    "Light of the moon moves west, flowers' shadows creep eastward...",
+	Visibility: models.VisibilityPrivate,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockRevisions is the history of mockSnippet, newest first.
+var mockRevisions = []*models.Revision{
+	{
+		ID:        2,
+		SnippetID: 1,
+		Number:    2,
+		Title:     "An old silent pond",
+		Content:   "An old silent pond...",
+		Created:   time.Now(),
+	},
+	{
+		ID:        1,
+		SnippetID: 1,
+		Number:    1,
+		Title:     "An old pond",
+		Content:   "An old pond...",
+		Created:   time.Now(),
+	},
+}
+
+// mockBurnSnippet is a burn-after-reading snippet which belongs to another
+// user.
+var mockBurnSnippet = &models.Snippet{
+	ID:               7,
+	Slug:             "password",
+	UserID:           2,
+	UserName:         "Bob",
+	Title:            "Database password",
+	Content:          "correct horse battery staple",
+	Visibility:       models.VisibilityUnlisted,
+	BurnAfterReading: true,
+	Created:          time.Now(),
+	Expires:          time.Now(),
+}
+
+// mockProtectedSnippet is a password protected snippet which belongs to
+// another user. Its password is "open sesame".
+var mockProtectedSnippet = &models.Snippet{
+	ID:         8,
+	Slug:       "keys",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "Deploy keys",
+	Content:    "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI...",
+	Visibility: models.VisibilityUnlisted,
+	Protected:  true,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockCodeSnippet is a public Go snippet which belongs to another user.
+var mockCodeSnippet = &models.Snippet{
+	ID:         9,
+	Slug:       "hello",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "Hello, world",
+	Content:    "package main\n\nfunc main() {\n\tprintln(\"<hello>\")\n}\n",
+	Language:   "go",
+	Visibility: models.VisibilityPublic,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockMarkdownSnippet is a public Markdown snippet which belongs to another
+// user. Its content tries to sneak a script onto the page.
+var mockMarkdownSnippet = &models.Snippet{
+	ID:         10,
+	Slug:       "runbook",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "Deploy runbook",
+	Content:    "# Deploying\n\n<script>alert(1)</script>\n\n[Dashboard](javascript:alert(1))\n",
+	Format:     models.FormatMarkdown,
+	Visibility: models.VisibilityPublic,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockMultiFileSnippet is a public snippet with further files, which belongs
+// to another user.
+var mockMultiFileSnippet = &models.Snippet{
+	ID:       11,
+	Slug:     "stack",
+	UserID:   2,
+	UserName: "Bob",
+	Title:    "Deploy stack",
+	Filename: "Dockerfile",
+	Content:  "FROM golang:1.23\nCOPY . .\nRUN go build ./cmd/web\n",
+	Format:   models.FormatText,
+	Language: "docker",
+	Files: []*models.File{
+		{Name: "config.yaml", Language: "yaml", Content: "addr: :4000\n"},
+		{Name: "run.sh", Language: "bash", Content: "#!/bin/sh\n./web -addr=:4000\n"},
+	},
+	Visibility: models.VisibilityPublic,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockForkSnippet is a public fork of mockSnippet which belongs to another
+// user.
+var mockForkSnippet = &models.Snippet{
+	ID:         12,
+	Slug:       "ripple",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "A frog jumps in",
+	Content:    "An old silent pond... A frog jumps into the pond, splash!",
+	ForkedFrom: 1,
+	Visibility: models.VisibilityPublic,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockPrivateForkSnippet is a private fork of mockSnippet which belongs to
+// another user. It mustn't be listed or counted among mockSnippet's forks.
+var mockPrivateForkSnippet = &models.Snippet{
+	ID:         14,
+	Slug:       "splash",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "The sound of water",
+	Content:    "An old silent pond... The sound of water.",
+	ForkedFrom: 1,
+	Visibility: models.VisibilityPrivate,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockPublicProtectedSnippet is a public, password protected snippet which
+// belongs to another user. Its password is "open sesame".
+var mockPublicProtectedSnippet = &models.Snippet{
+	ID:         13,
+	Slug:       "vault",
+	UserID:     2,
+	UserName:   "Bob",
+	Title:      "Vault token",
+	Content:    "hvs.vaultsecret and the root token for staging",
+	Visibility: models.VisibilityPublic,
+	Protected:  true,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockSnippets holds every snippet which Get and GetBySlug know about.
+var mockSnippets = []*models.Snippet{
+	mockSnippet,
+	mockForeignSnippet,
+	mockPrivateSnippet,
+	mockUnlistedSnippet,
+	mockOwnPrivateSnippet,
+	mockBurnSnippet,
+	mockProtectedSnippet,
+	mockCodeSnippet,
+	mockMarkdownSnippet,
+	mockMultiFileSnippet,
+	mockForkSnippet,
+	mockPublicProtectedSnippet,
+	mockPrivateForkSnippet,
 }
 
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
-	return 2, nil
+func (m *SnippetModel) Insert(s *models.Snippet, password string) (string, error) {
+	return "fresh", nil
 }
 
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+	for _, s := range mockSnippets {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+	for _, s := range mockSnippets {
+		if s.Slug == slug {
+			return s, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// Page pretends that the public snippets are split over two pages, with
+// mockSnippet on the first page and mockForeignSnippet on the second. Pages
+// of tagged snippets hold every public snippet with the tag.
+func (m *SnippetModel) Page(req models.PageRequest) (*models.Page, error) {
+	if req.Tag != "" {
+		snippets := []*models.Snippet{}
+		for _, s := range mockSnippets {
+			if s.Visibility == models.VisibilityPublic && !s.BurnAfterReading && slices.Contains(s.Tags, req.Tag) {
+				snippets = append(snippets, s)
+			}
+		}
+		return &models.Page{Snippets: snippets}, nil
+	}
+
+	first := &models.Cursor{Created: mockSnippet.Created, ID: mockSnippet.ID}
+	second := &models.Cursor{Created: mockForeignSnippet.Created, ID: mockForeignSnippet.ID}
+
+	switch {
+	case req.After != nil && req.After.ID == first.ID:
+		return &models.Page{Snippets: []*models.Snippet{mockForeignSnippet}, Prev: second}, nil
+	case req.After != nil, req.Before != nil && req.Before.ID == first.ID:
+		return &models.Page{Snippets: []*models.Snippet{}}, nil
+	default:
+		return &models.Page{Snippets: []*models.Snippet{mockSnippet}, Next: first}, nil
+	}
+}
+
+// Search does a case-insensitive substring match on the title and content of
+// the public snippets which aren't password protected, rather than a real
+// full-text search.
+func (m *SnippetModel) Search(query string, limit int) ([]*models.Snippet, error) {
+	query = strings.ToLower(query)
+
+	snippets := []*models.Snippet{}
+	for _, s := range mockSnippets {
+		if len(snippets) == limit {
+			break
+		}
+		if s.Visibility != models.VisibilityPublic || s.BurnAfterReading || s.Protected {
+			continue
+		}
+		if strings.Contains(strings.ToLower(s.Title+" "+s.Content), query) {
+			snippets = append(snippets, s)
+		}
+	}
+	return snippets, nil
+}
+
+func (m *SnippetModel) Forks(id int) ([]*models.Snippet, error) {
+	forks := []*models.Snippet{}
+	for _, s := range mockSnippets {
+		if s.ForkedFrom == id && s.Visibility == models.VisibilityPublic && !s.BurnAfterReading {
+			forks = append(forks, s)
+		}
+	}
+	return forks, nil
+}
+
+func (m *SnippetModel) ForkCount(id int) (int, error) {
+	forks, err := m.Forks(id)
+	if err != nil {
+		return 0, err
+	}
+	return len(forks), nil
+}
+
+func (m *SnippetModel) Update(id int, title, content, visibility string) error {
+	return nil
+}
+
+func (m *SnippetModel) Delete(id int) error {
+	return nil
+}
+
+// Unlock accepts "open sesame" for every protected snippet.
+func (m *SnippetModel) Unlock(id int, password string) error {
+	s, err := m.Get(id)
+	if err == nil && s.Protected && password == "open sesame" {
+		return nil
+	}
+	return models.ErrInvalidCredentials
+}
+
+func (m *SnippetModel) Burn(id int) (*models.Snippet, error) {
 	switch id {
-	case 1:
-		return mockSnippet, nil
+	case 7:
+		return mockBurnSnippet, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	return []*models.Snippet{mockSnippet}, nil
+func (m *SnippetModel) DeleteExpired(before time.Time) (int, error) {
+	return 3, nil
+}
+
+func (m *SnippetModel) Revisions(id int) ([]*models.Revision, error) {
+	switch id {
+	case 1:
+		return mockRevisions, nil
+	default:
+		return []*models.Revision{}, nil
+	}
+}
+
+// Revision also returns two very long revisions of mockForeignSnippet, which
+// aren't part of its listed history.
+func (m *SnippetModel) Revision(id, number int) (*models.Revision, error) {
+	if id == 1 && number >= 1 && number <= len(mockRevisions) {
+		return mockRevisions[len(mockRevisions)-number], nil
+	}
+	if id == mockForeignSnippet.ID && (number == 1 || number == 2) {
+		return &models.Revision{
+			SnippetID: id,
+			Number:    number,
+			Title:     mockForeignSnippet.Title,
+			Content:   strings.Repeat(fmt.Sprintf("revision %d\n", number), 100000),
+			Created:   time.Now(),
+		}, nil
+	}
+	return nil, models.ErrNoRecord
 }
@@ -0,0 +1,52 @@
+package mock
+
+import (
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"time"
+)
+
+// mockStars maps snippet IDs to the IDs of the users who starred them. The
+// mock user starred mockSnippet, along with another user.
+var mockStars = map[int][]int{
+	1: {1, 2},
+	3: {2},
+}
+
+type StarModel struct{}
+
+func (m *StarModel) Toggle(snippetID, userID int) (bool, error) {
+	starred, err := m.Starred(snippetID, userID)
+	return !starred, err
+}
+
+func (m *StarModel) Starred(snippetID, userID int) (bool, error) {
+	for _, id := range mockStars[snippetID] {
+		if id == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *StarModel) Count(snippetID int) (int, error) {
+	return len(mockStars[snippetID]), nil
+}
+
+func (m *StarModel) ForUser(userID int) ([]*models.Snippet, error) {
+	switch userID {
+	case 1:
+		return []*models.Snippet{mockSnippet}, nil
+	default:
+		return []*models.Snippet{}, nil
+	}
+}
+
+func (m *StarModel) MostStarred(since time.Time, limit int) ([]*models.Snippet, error) {
+	snippets := []*models.Snippet{}
+	for _, s := range []*models.Snippet{mockSnippet, mockForeignSnippet} {
+		starred := *s
+		starred.Stars = len(mockStars[s.ID])
+		snippets = append(snippets, &starred)
+	}
+	return snippets, nil
+}
@@ -1,42 +1,229 @@
 package mock
 
 import (
+	"bytes"
 	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"github.com/TeslaMode1X/snippetbox/pkg/totp"
+	"sync"
 	"time"
 )
 
 var mockUser = &models.User{
-	ID:      1,
-	Name:    "Alice",
-	Email:   "alice@example.com",
+	ID:       1,
+	Name:     "Alice",
+	Email:    "alice@example.com",
+	Verified: true,
+	Created:  time.Now(),
+}
+
+// mockUnverifiedUser has signed up but not verified their email address yet.
+var mockUnverifiedUser = &models.User{
+	ID:      3,
+	Name:    "Carol",
+	Email:   "carol@example.com",
 	Created: time.Now(),
 }
 
-type UserModel struct{}
+// UserModel hands out copies of the mock users, so that what one test does
+// to a user doesn't leak into the next. Changes such as a pending address
+// or a bumped session version are made to the model's own copies. The zero
+// value is ready to use.
+type UserModel struct {
+	mu    sync.Mutex
+	users map[int]*models.User
+}
+
+// user returns the model's own copy of a user, making it on first use. The
+// caller must hold m.mu.
+func (m *UserModel) user(id int) (*models.User, error) {
+	if u, ok := m.users[id]; ok {
+		return u, nil
+	}
+
+	var u models.User
+	switch id {
+	case 1:
+		u = *mockUser
+	case 3:
+		u = *mockUnverifiedUser
+	case 4:
+		u = *mockTOTPUser
+	default:
+		return nil, models.ErrNoRecord
+	}
+
+	if m.users == nil {
+		m.users = map[int]*models.User{}
+	}
+	m.users[id] = &u
+	return &u, nil
+}
+
+// change calls fn with the model's copy of a user.
+func (m *UserModel) change(id int, fn func(*models.User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.user(id)
+	if err != nil {
+		return err
+	}
+	fn(u)
+	return nil
+}
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	switch email {
 	case "dupe@example.com":
-		return models.ErrDuplicateEmail
+		return 0, models.ErrDuplicateEmail
 	default:
-		return nil
+		return mockUnverifiedUser.ID, nil
 	}
 }
 
+// mockTOTPUser has turned on two-factor authentication with mockTOTPSecret,
+// and has one recovery code left, mockRecoveryCode.
+var mockTOTPUser = &models.User{
+	ID:          4,
+	Name:        "Dave",
+	Email:       "dave@example.com",
+	Verified:    true,
+	TOTPEnabled: true,
+	Created:     time.Now(),
+}
+
+const (
+	mockTOTPSecret   = "JBSWY3DPEHPK3PXP"
+	mockRecoveryCode = "abcd-efgh"
+)
+
+// mockPassword is the password of every mock user.
+const mockPassword = "validPa$$word"
+
 func (m *UserModel) Authenticate(email, password string) (int, error) {
-	switch email {
-	case "alice@example.com":
-		return 1, nil
-	default:
+	if password != mockPassword {
 		return 0, models.ErrInvalidCredentials
 	}
+
+	user, err := m.GetByEmail(email)
+	if err == models.ErrNoRecord {
+		return 0, models.ErrInvalidCredentials
+	} else if err != nil {
+		return 0, err
+	}
+	if !user.Verified {
+		return 0, models.ErrNotVerified
+	}
+	return user.ID, nil
 }
 
 func (m *UserModel) Get(id int) (*models.User, error) {
-	switch id {
-	case 1:
-		return mockUser, nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.user(id)
+	if err != nil {
+		return nil, err
+	}
+	user := *u
+	return &user, nil
+}
+
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	for _, id := range []int{mockUser.ID, mockUnverifiedUser.ID, mockTOTPUser.ID} {
+		u, err := m.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) Verify(id int) error {
+	return m.change(id, func(u *models.User) { u.Verified = true })
+}
+
+func (m *UserModel) UpdateName(id int, name string) error {
+	return m.change(id, func(u *models.User) { u.Name = name })
+}
+
+// SetPendingEmail records the address the user wants to change to, like the
+// real model does.
+func (m *UserModel) SetPendingEmail(id int, email string) error {
+	other, err := m.GetByEmail(email)
+	if email == "dupe@example.com" || (err == nil && other.ID != id) {
+		return models.ErrDuplicateEmail
+	}
+	return m.change(id, func(u *models.User) { u.PendingEmail = email })
+}
+
+// ConfirmEmail makes the user's pending address their email address, as
+// long as it is still the given one.
+func (m *UserModel) ConfirmEmail(id int, email string) error {
+	user, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	if user.PendingEmail == "" || user.PendingEmail != email {
+		return models.ErrNoRecord
+	}
+	return m.change(id, func(u *models.User) {
+		u.Email = u.PendingEmail
+		u.PendingEmail = ""
+	})
+}
+
+// ChangePassword bumps the user's session version, like the real model
+// does.
+func (m *UserModel) ChangePassword(id int, password string) error {
+	return m.change(id, func(u *models.User) { u.SessionVersion++ })
+}
+
+// NewResetToken always returns "valid-token", which ResetPassword accepts
+// for mockUser.
+func (m *UserModel) NewResetToken(userID int, ttl time.Duration) (string, error) {
+	return "valid-token", nil
+}
+
+// ResetPassword logs mockUser out of their existing sessions by bumping
+// their session version, like the real model does.
+func (m *UserModel) ResetPassword(token, password string) (int, error) {
+	switch token {
+	case "valid-token":
+		err := m.change(mockUser.ID, func(u *models.User) { u.SessionVersion++ })
+		return mockUser.ID, err
 	default:
-		return nil, models.ErrNoRecord
+		return 0, models.ErrInvalidToken
+	}
+}
+
+func (m *UserModel) EnableTOTP(id int, secret string, step int64, recoveryHashes [][]byte) error {
+	_, err := m.Get(id)
+	return err
+}
+
+func (m *UserModel) DisableTOTP(id int) error {
+	_, err := m.Get(id)
+	return err
+}
+
+func (m *UserModel) TOTPSecret(id int) (string, error) {
+	if id != mockTOTPUser.ID {
+		return "", models.ErrNoRecord
+	}
+	return mockTOTPSecret, nil
+}
+
+func (m *UserModel) UseTOTPStep(id int, step int64) error {
+	return nil
+}
+
+func (m *UserModel) UseRecoveryCode(id int, hash []byte) error {
+	if id != mockTOTPUser.ID || !bytes.Equal(hash, totp.HashRecoveryCode(mockRecoveryCode)) {
+		return models.ErrInvalidToken
 	}
+	return nil
 }
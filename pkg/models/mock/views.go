@@ -0,0 +1,25 @@
+package mock
+
+import (
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"time"
+)
+
+type ViewModel struct{}
+
+func (m *ViewModel) Add(counts map[models.ViewDay]int) error {
+	return nil
+}
+
+// Daily reports views of mockSnippet today and two days ago.
+func (m *ViewModel) Daily(snippetID int, since time.Time) ([]*models.DailyViews, error) {
+	if snippetID != 1 {
+		return []*models.DailyViews{}, nil
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	return []*models.DailyViews{
+		{Day: today.AddDate(0, 0, -2), Views: 5},
+		{Day: today, Views: 3},
+	}, nil
+}
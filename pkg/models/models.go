@@ -1,7 +1,9 @@
 package models
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -13,22 +15,194 @@ var (
 	// ErrDuplicateEmail Add a new ErrDuplicateEmail error. We'll use this later if a user
 	// tries to signup with an email address that's already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	// ErrDuplicateSlug is returned when a newly generated snippet slug is
+	// already taken.
+	ErrDuplicateSlug = errors.New("models: duplicate slug")
+	// ErrInvalidCursor is returned when a pagination cursor can't be decoded.
+	ErrInvalidCursor = errors.New("models: invalid cursor")
+	// ErrNotVerified is returned when a user logs in with the right password
+	// but hasn't verified their email address yet.
+	ErrNotVerified = errors.New("models: email address not verified")
+	// ErrInvalidToken is returned for a password reset token, two-factor
+	// code or recovery code which doesn't exist, has expired or has already
+	// been used.
+	ErrInvalidToken = errors.New("models: invalid or expired token")
 )
 
+// The visibility settings a snippet can have. Public snippets are listed on
+// the home page, unlisted snippets can only be reached by their link, and
+// private snippets can only be seen by their owner.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// The formats a snippet's content can be written in. Text is shown as it is,
+// highlighted according to the snippet's language, and Markdown is rendered
+// to HTML.
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+)
+
+// Never is the expiry time given to snippets which never expire. It is the
+// latest time that a MySQL DATETIME column can hold.
+var Never = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// Snippet holds a single snippet. Slug is the random, unguessable string which
+// identifies the snippet in URLs; ID is only used internally. UserID is the ID
+// of the user who created it and UserName is that user's name, filled in by
+// queries which join on the users table. A BurnAfterReading snippet is deleted
+// the first time it is read by someone other than its owner, and a Protected
+// snippet has a password which readers need to know to see its content. Format
+// is one of the Format constants, and Language is the language text content is
+// highlighted as, or empty for plain text.
+//
+// Content is the snippet's main file, and Filename is its name if the author
+// gave it one. Files holds any further files which were shared along with
+// it, in order. ForkedFrom is the ID of the snippet this one was copied from,
+// or 0 if it wasn't.
+//
+// Stars is only filled in by queries which rank snippets by their stars, and
+// holds the number of stars counted by that query.
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
+	ID               int
+	Slug             string
+	UserID           int
+	UserName         string
+	Title            string
+	Filename         string
+	Content          string
+	Format           string
+	Language         string
+	Files            []*File
+	ForkedFrom       int
+	Visibility       string
+	BurnAfterReading bool
+	Protected        bool
+	Tags             []string
+	Stars            int
+	Created          time.Time
+	Expires          time.Time
+}
+
+// NeverExpires reports whether the snippet is kept forever.
+func (s *Snippet) NeverExpires() bool {
+	return !s.Expires.Before(Never)
+}
+
+// File is one of the further files of a multi-file snippet. Language is the
+// language it is highlighted as, or empty for plain text.
+type File struct {
+	Name     string
+	Language string
+	Content  string
+}
+
+// Cursor marks a position in a list of snippets sorted by creation time. The
+// ID breaks ties between snippets created at the same moment.
+type Cursor struct {
 	Created time.Time
-	Expires time.Time
+	ID      int
+}
+
+// String encodes the cursor as an opaque, URL-safe token.
+func (c Cursor) String() string {
+	raw := fmt.Sprintf("%d:%d", c.Created.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a token created by Cursor.String.
+func ParseCursor(token string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var nanos int64
+	var id int
+	_, err = fmt.Sscanf(string(raw), "%d:%d", &nanos, &id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{Created: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// PageRequest describes a page of snippets to fetch. With neither After nor
+// Before set it asks for the first page; otherwise it asks for the Limit
+// snippets which come directly after or before the cursor. If Tag is set only
+// snippets with that tag are included.
+type PageRequest struct {
+	Limit       int
+	NewestFirst bool
+	Tag         string
+	After       *Cursor
+	Before      *Cursor
+}
+
+// Page is a page of snippets. Next and Prev point at the neighbouring pages,
+// and are nil when there is no such page.
+type Page struct {
+	Snippets []*Snippet
+	Next     *Cursor
+	Prev     *Cursor
+}
+
+// Revision is a saved version of a snippet. Every snippet starts out with
+// revision number 1 and each edit adds a revision with the next number.
+type Revision struct {
+	ID        int
+	SnippetID int
+	Number    int
+	Title     string
+	Content   string
+	Created   time.Time
+}
+
+// DailyViews is the number of times a snippet was viewed on a day, in UTC.
+type DailyViews struct {
+	Day   time.Time
+	Views int
+}
+
+// ViewDay identifies the views of a snippet on one day, in UTC. Day is the
+// midnight that day starts at.
+type ViewDay struct {
+	SnippetID int
+	Day       time.Time
+}
+
+// Comment is a remark left on a snippet by a logged in user. Line is the
+// line of the snippet's main file which the comment is about, or 0 if it is
+// about the snippet as a whole. UserName is filled in by joining on the users
+// table, like it is for snippets.
+type Comment struct {
+	ID        int
+	SnippetID int
+	UserID    int
+	UserName  string
+	Line      int
+	Content   string
+	Created   time.Time
 }
 
 // User Define a new User type. Notice how the field names and types align
-// with the columns in the database `users` table?
+// with the columns in the database `users` table? Verified is set once the
+// user has followed the link emailed to them on signup. PendingEmail is an
+// address they asked to change to but haven't confirmed yet. SessionVersion
+// goes up whenever the user's existing sessions should stop working, such as
+// when their password is reset. TOTPEnabled is set for users who have turned
+// on two-factor authentication.
 type User struct {
 	ID             int
 	Name           string
 	Email          string
+	PendingEmail   string
 	HashedPassword []byte
+	Verified       bool
+	SessionVersion int
+	TOTPEnabled    bool
 	Created        time.Time
 }
@@ -0,0 +1,62 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginAttemptModel counts failed logins in the login_failures table, one
+// row per key, such as an account's email address or a client's IP address.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Get returns the number of failed logins for the key, along with the time
+// of the last one.
+func (m *LoginAttemptModel) Get(key string) (int, time.Time, error) {
+	stmt := `SELECT failures, last_failure FROM login_failures WHERE key_name = ?`
+
+	var failures int
+	var last time.Time
+	err := m.DB.QueryRow(stmt, key).Scan(&failures, &last)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	return failures, last, err
+}
+
+// Fail records a failed login for the key and returns how many there are
+// now. If the last failure was before reset, counting starts again from one.
+// The count is updated and read back in a single statement, so concurrent
+// failures are all counted and each gets a count of its own.
+func (m *LoginAttemptModel) Fail(key string, reset time.Time) (int, error) {
+	// MySQL evaluates the assignments in order, so failures is worked out
+	// from the previous value of last_failure. LAST_INSERT_ID(expr) hands the
+	// new count back to this connection through the result's insert ID.
+	stmt := `INSERT INTO login_failures (key_name, failures, last_failure) VALUES(?, LAST_INSERT_ID(1), UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE failures = LAST_INSERT_ID(IF(last_failure < ?, 1, failures + 1)), last_failure = UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt, key, reset.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	failures, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(failures), nil
+}
+
+// Forgive takes back one failed login for the key, for an attempt which was
+// counted as failed before it turned out to be right.
+func (m *LoginAttemptModel) Forgive(key string) error {
+	_, err := m.DB.Exec(`UPDATE login_failures SET failures = failures - 1 WHERE key_name = ? AND failures > 0`, key)
+	return err
+}
+
+// Clear forgets the failed logins for the key.
+func (m *LoginAttemptModel) Clear(key string) error {
+	_, err := m.DB.Exec(`DELETE FROM login_failures WHERE key_name = ?`, key)
+	return err
+}
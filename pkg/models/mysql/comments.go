@@ -0,0 +1,81 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+)
+
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a comment by the given user to a snippet and returns its ID.
+// A line of 0 means that the comment is about the snippet as a whole.
+func (m *CommentModel) Insert(snippetID, userID, line int, content string) (int, error) {
+	stmt := `INSERT INTO comments (snippet_id, user_id, line, content, created)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, snippetID, userID, line, content)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get returns a single comment.
+func (m *CommentModel) Get(id int) (*models.Comment, error) {
+	stmt := `SELECT c.id, c.snippet_id, c.user_id, u.name, c.line, c.content, c.created
+	FROM comments c INNER JOIN users u ON u.id = c.user_id WHERE c.id = ?`
+
+	c := &models.Comment{}
+	err := m.DB.QueryRow(stmt, id).Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &c.Line, &c.Content, &c.Created)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ForSnippet returns the comments on a snippet, oldest first, so that they
+// read like a conversation.
+func (m *CommentModel) ForSnippet(snippetID int) ([]*models.Comment, error) {
+	stmt := `SELECT c.id, c.snippet_id, c.user_id, u.name, c.line, c.content, c.created
+	FROM comments c INNER JOIN users u ON u.id = c.user_id
+	WHERE c.snippet_id = ? ORDER BY c.created, c.id`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*models.Comment{}
+	for rows.Next() {
+		c := &models.Comment{}
+		err = rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &c.Line, &c.Content, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Delete removes a comment. Checking that the current user wrote it is up to
+// the caller.
+func (m *CommentModel) Delete(id int) error {
+	_, err := m.DB.Exec(`DELETE FROM comments WHERE id = ?`, id)
+	return err
+}
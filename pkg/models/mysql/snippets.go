@@ -1,76 +1,608 @@
 package mysql
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
+	"slices"
+	"strings"
+	"time"
 )
 
 type SnippetModel struct {
 	DB *sql.DB
 }
 
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
-	stmt := `INSERT INTO snippets (title, content, created, expires) 
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+// slugAttempts is how many fresh slugs Insert tries before giving up.
+const slugAttempts = 5
 
-	// Use the Exec() method on the embedded connection pool to execute the
-	// statement. The first parameter is the SQL statement, followed by the
-	// title, content and expiry values for the placeholder parameters. This
-	// method returns a sql.Result object, which contains some basic
-	// information about what happened when the statement was executed.
-	result, err := m.DB.Exec(stmt, title, content, expires)
+// newSlug returns a random, URL-safe slug. It encodes 64 random bits, which
+// makes guessing the slug of any particular snippet impractical.
+func newSlug() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
 	if err != nil {
-		return 0, err
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// snippetColumns lists the columns which scanSnippet expects, in order. The
+// snippets table is aliased as s and joined on the users table, aliased as u,
+// so that the author's name comes back along with the snippet itself.
+const snippetColumns = `s.id, s.slug, s.user_id, u.name, s.title, s.filename, s.content, s.format,
+	s.language, COALESCE(s.forked_from, 0), s.visibility, s.burn_after_reading, s.hashed_password IS NOT NULL, s.created, s.expires`
+
+// snippetFrom is the FROM clause which goes with snippetColumns.
+const snippetFrom = `FROM snippets s INNER JOIN users u ON u.id = s.user_id`
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSnippet copies a row selected with snippetColumns into a new Snippet.
+func scanSnippet(row scanner) (*models.Snippet, error) {
+	s := &models.Snippet{}
+	err := row.Scan(&s.ID, &s.Slug, &s.UserID, &s.UserName, &s.Title, &s.Filename, &s.Content, &s.Format,
+		&s.Language, &s.ForkedFrom, &s.Visibility, &s.BurnAfterReading, &s.Protected, &s.Created, &s.Expires)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Insert adds a new snippet and returns its slug. The owner, title, files,
+// format, language, parent, visibility, burn-after-reading flag, tags and
+// expiry time are taken from s. If password isn't empty the snippet is
+// protected by it, and only a bcrypt hash of the password is stored.
+func (m *SnippetModel) Insert(s *models.Snippet, password string) (string, error) {
+	var hashedPassword []byte
+	if password != "" {
+		var err error
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(password), 12)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	forkedFrom := sql.NullInt64{Int64: int64(s.ForkedFrom), Valid: s.ForkedFrom != 0}
+
+	// The snippet, its files and its first revision are written in a single
+	// transaction, so a snippet never exists without its history.
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO snippets (slug, user_id, title, filename, content, format, language, forked_from, visibility, burn_after_reading, hashed_password, created, expires) 
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), ?)`
+
+	// Slugs are random, so a new one can clash with one which is already in
+	// use. When that happens the unique index on the slug column rejects the
+	// row and we simply try again with a fresh slug.
+	var slug string
+	var result sql.Result
+	for attempt := 0; ; attempt++ {
+		if attempt == slugAttempts {
+			return "", models.ErrDuplicateSlug
+		}
+
+		slug, err = newSlug()
+		if err != nil {
+			return "", err
+		}
+
+		// Use the Exec() method on the transaction to execute the statement.
+		// The first parameter is the SQL statement, followed by the slug,
+		// owner, title, filename, content, format, language, parent,
+		// visibility, burn, password and expiry values for the placeholder
+		// parameters. A nil hashedPassword and a snippet which isn't a fork
+		// are stored as NULL. This method returns a sql.Result object, which contains some
+		// basic information about what happened when the statement was
+		// executed.
+		result, err = tx.Exec(stmt, slug, s.UserID, s.Title, s.Filename, s.Content, s.Format, s.Language,
+			forkedFrom, s.Visibility, s.BurnAfterReading, hashedPassword, s.Expires.UTC())
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "snippets_uc_slug") {
+				continue
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		break
 	}
 
 	// Use the LastInsertId() method on the result object to get the ID of our
 	// newly inserted record in the snippets table.
 	id, err := result.LastInsertId()
 	if err != nil {
-		return 0, err
+		return "", err
+	}
+
+	err = insertRevision(tx, int(id), s.Title, s.Content)
+	if err != nil {
+		return "", err
 	}
 
-	// The ID returned has the type int64, so we convert it to an int type
-	// before returning.
-	return int(id), nil
+	err = insertFiles(tx, int(id), s.Files)
+	if err != nil {
+		return "", err
+	}
+
+	err = insertTags(tx, int(id), s.Tags)
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return slug, nil
 }
 
+// Get returns a live snippet. The snippets table always holds the title and
+// content of the latest revision, so no join on revisions is needed here.
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
-	stmt := `select id, title, content, created, expires 
-	from snippets where expires > UTC_TIMESTAMP() AND id = ?`
+	stmt := `SELECT ` + snippetColumns + ` ` + snippetFrom + `
+	WHERE s.expires > UTC_TIMESTAMP() AND s.id = ?`
 
 	row := m.DB.QueryRow(stmt, id)
 
-	s := &models.Snippet{}
+	// Use scanSnippet() to copy the values from each field in sql.Row to the
+	// corresponding field in a new Snippet struct. If the query returns no
+	// rows, then row.Scan() will return a sql.ErrNoRows error. We check for
+	// that and return our own models.ErrNoRecord error instead of a Snippet
+	// object.
+	s, err := scanSnippet(row)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = loadExtras(m.DB, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetBySlug works like Get, but looks the snippet up by its slug.
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` ` + snippetFrom + `
+	WHERE s.expires > UTC_TIMESTAMP() AND s.slug = ?`
+
+	s, err := scanSnippet(m.DB.QueryRow(stmt, slug))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = loadExtras(m.DB, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// loadExtras fills in the tags and further files of a snippet, which are
+// kept in tables of their own.
+func loadExtras(q querier, s *models.Snippet) error {
+	var err error
+	s.Tags, err = queryTags(q, s.ID)
+	if err != nil {
+		return err
+	}
+
+	s.Files, err = queryFiles(q, s.ID)
+	return err
+}
+
+// queryFiles returns the further files of a snippet, in order.
+func queryFiles(q querier, id int) ([]*models.File, error) {
+	stmt := `SELECT name, language, content FROM snippet_files
+	WHERE snippet_id = ? ORDER BY position`
+
+	rows, err := q.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []*models.File{}
+	for rows.Next() {
+		f := &models.File{}
+		if err = rows.Scan(&f.Name, &f.Language, &f.Content); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// queryTags returns the tags of a snippet in alphabetical order.
+func queryTags(q querier, id int) ([]string, error) {
+	stmt := `SELECT t.name FROM tags t INNER JOIN snippet_tags st ON st.tag_id = t.id
+	WHERE st.snippet_id = ? ORDER BY t.name`
+
+	rows, err := q.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err = rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// Update changes the title, content and visibility of a snippet and records
+// the result as a new revision.
+func (m *SnippetModel) Update(id int, title, content, visibility string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Updating the snippet row locks it until the transaction ends, which
+	// keeps concurrent edits from picking the same revision number.
+	stmt := `UPDATE snippets SET title = ?, content = ?, visibility = ? WHERE id = ?`
+
+	_, err = tx.Exec(stmt, title, content, visibility, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(tx, id, title, content)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *SnippetModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteSnippets(tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Unlock checks the password of a protected snippet. It returns
+// models.ErrInvalidCredentials if the password is wrong, or if the snippet
+// doesn't have a password at all.
+func (m *SnippetModel) Unlock(id int, password string) error {
+	var hashedPassword []byte
+	stmt := `SELECT hashed_password FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND id = ? AND hashed_password IS NOT NULL`
+	err := m.DB.QueryRow(stmt, id).Scan(&hashedPassword)
+	if err == sql.ErrNoRows {
+		return models.ErrInvalidCredentials
+	} else if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return models.ErrInvalidCredentials
+	}
+	return err
+}
+
+// Burn fetches and deletes a burn-after-reading snippet in one go. The row is
+// locked while it is read, so when two viewers ask for the same snippet at
+// once only one of them gets it back; the other gets models.ErrNoRecord.
+func (m *SnippetModel) Burn(id int) (*models.Snippet, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	// Use row.Scan() to copy the values from each field in sql.Row to the
-	// corresponding field in the Snippet struct. Notice that the arguments
-	// to row.Scan are *pointers* to the place you want to copy the data into,
-	// and the number of arguments must be exactly the same as the number of
-	// columns returned by your statement. If the query returns no rows, then
-	// row.Scan() will return a sql.ErrNoRows error. We check for that and retu
-	// our own models.ErrNoRecord error instead of a Snippet object.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	stmt := `SELECT ` + snippetColumns + ` ` + snippetFrom + `
+	WHERE s.expires > UTC_TIMESTAMP() AND s.id = ? AND s.burn_after_reading = TRUE
+	FOR UPDATE`
+
+	s, err := scanSnippet(tx.QueryRow(stmt, id))
 	if err == sql.ErrNoRows {
 		return nil, models.ErrNoRecord
 	} else if err != nil {
 		return nil, err
 	}
 
+	// The reader gets to see all of the snippet once, so its files have to
+	// be read before they're deleted along with it.
+	err = loadExtras(tx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deleteSnippets(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY created ASC LIMIT 10`
+// expiredBatchSize is how many expired snippets DeleteExpired removes in each
+// transaction. Working in batches keeps the locks it takes short-lived.
+const expiredBatchSize = 500
+
+// DeleteExpired permanently removes every snippet which expired before the
+// given time and returns how many were removed.
+func (m *SnippetModel) DeleteExpired(before time.Time) (int, error) {
+	total := 0
+	for {
+		n, err := m.deleteExpiredBatch(before)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < expiredBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// deleteExpiredBatch removes up to expiredBatchSize expired snippets.
+func (m *SnippetModel) deleteExpiredBatch(before time.Time) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT id FROM snippets WHERE expires <= ? ORDER BY id LIMIT ? FOR UPDATE`
+
+	rows, err := tx.Query(stmt, before.UTC(), expiredBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
 
-	rows, err := m.DB.Query(stmt)
+	err = deleteSnippets(tx, ids...)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// Revisions returns every saved version of a snippet, newest first.
+func (m *SnippetModel) Revisions(id int) ([]*models.Revision, error) {
+	stmt := `SELECT id, snippet_id, number, title, content, created FROM revisions
+	WHERE snippet_id = ? ORDER BY number DESC`
+
+	rows, err := m.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*models.Revision{}
+
+	for rows.Next() {
+		rv := &models.Revision{}
+		err = rows.Scan(&rv.ID, &rv.SnippetID, &rv.Number, &rv.Title, &rv.Content, &rv.Created)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Revision returns a single version of a snippet by its revision number.
+func (m *SnippetModel) Revision(id, number int) (*models.Revision, error) {
+	stmt := `SELECT id, snippet_id, number, title, content, created FROM revisions
+	WHERE snippet_id = ? AND number = ?`
+
+	rv := &models.Revision{}
+	err := m.DB.QueryRow(stmt, id, number).Scan(&rv.ID, &rv.SnippetID, &rv.Number, &rv.Title, &rv.Content, &rv.Created)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// Page returns a page of live public snippets. Unlisted and private snippets
+// are never listed, and neither are burn-after-reading ones, which are meant
+// for a single reader.
+//
+// Pages are found with a cursor (the creation time and ID of the snippet at
+// the edge of the neighbouring page) rather than an offset, so that the
+// query stays fast deep into the archive and snippets created in the
+// meantime don't shift what's on each page.
+func (m *SnippetModel) Page(req models.PageRequest) (*models.Page, error) {
+	// When paging backwards we walk the list in the opposite direction,
+	// starting at the cursor, and put the snippets back in order afterwards.
+	backwards := req.Before != nil
+	descending := req.NewestFirst != backwards
+
+	cmp, order := ">", "ASC"
+	if descending {
+		cmp, order = "<", "DESC"
+	}
+
+	stmt := `SELECT ` + snippetColumns + ` ` + snippetFrom + `
+	WHERE s.expires > UTC_TIMESTAMP() AND s.visibility = ? AND s.burn_after_reading = FALSE`
+	args := []interface{}{models.VisibilityPublic}
+
+	if req.Tag != "" {
+		stmt += ` AND s.id IN (SELECT st.snippet_id FROM snippet_tags st
+		INNER JOIN tags t ON t.id = st.tag_id WHERE t.name = ?)`
+		args = append(args, req.Tag)
+	}
+
+	cursor := req.After
+	if backwards {
+		cursor = req.Before
+	}
+	if cursor != nil {
+		stmt += ` AND (s.created ` + cmp + ` ? OR (s.created = ? AND s.id ` + cmp + ` ?))`
+		args = append(args, cursor.Created, cursor.Created, cursor.ID)
+	}
+
+	// Ask for one snippet more than fits on the page, to find out whether
+	// there is another page beyond this one.
+	stmt += ` ORDER BY s.created ` + order + `, s.id ` + order + ` LIMIT ?`
+	args = append(args, req.Limit+1)
+
+	snippets, err := querySnippets(m.DB, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	more := len(snippets) > req.Limit
+	if more {
+		snippets = snippets[:req.Limit]
+	}
+	if backwards {
+		slices.Reverse(snippets)
+	}
+
+	page := &models.Page{Snippets: snippets}
+	if len(snippets) == 0 {
+		return page, nil
+	}
+
+	first, last := snippets[0], snippets[len(snippets)-1]
+	// There is a previous page if we came from one, or if we went backwards
+	// and found more snippets than fit; and the same the other way round.
+	if req.After != nil || (backwards && more) {
+		page.Prev = &models.Cursor{Created: first.Created, ID: first.ID}
+	}
+	if backwards || more {
+		page.Next = &models.Cursor{Created: last.Created, ID: last.ID}
+	}
+
+	return page, nil
+}
+
+// Search returns up to limit live public snippets whose title or content
+// matches the query, best matches first. Like Page, it never lists unlisted,
+// private or burn-after-reading snippets. Password protected snippets are
+// left out too, since the results show an excerpt of the content.
+//
+// The query is run in natural language mode against the FULLTEXT index
+// which migrations/009_snippet_search.sql creates.
+func (m *SnippetModel) Search(query string, limit int) ([]*models.Snippet, error) {
+	// MySQL notices that the MATCH() expressions in the WHERE and ORDER BY
+	// clauses are the same, so the relevance is only worked out once.
+	stmt := `SELECT ` + snippetColumns + ` ` + snippetFrom + `
+	WHERE MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE)
+	AND s.expires > UTC_TIMESTAMP() AND s.visibility = ? AND s.burn_after_reading = FALSE
+	AND s.hashed_password IS NULL
+	ORDER BY MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE) DESC, s.created DESC
+	LIMIT ?`
+
+	return querySnippets(m.DB, stmt, query, models.VisibilityPublic, query, limit)
+}
+
+// Forks returns the live public forks of a snippet, newest first. Forks
+// which aren't public are left out, just as they are from every other list.
+func (m *SnippetModel) Forks(id int) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` ` + snippetFrom + `
+	WHERE s.forked_from = ? AND s.expires > UTC_TIMESTAMP()
+	AND s.visibility = ? AND s.burn_after_reading = FALSE
+	ORDER BY s.created DESC, s.id DESC`
+
+	return querySnippets(m.DB, stmt, id, models.VisibilityPublic)
+}
+
+// ForkCount returns how many forks Forks would return for a snippet, so that
+// private and unlisted forks can't be discovered by counting them.
+func (m *SnippetModel) ForkCount(id int) (int, error) {
+	stmt := `SELECT COUNT(*) FROM snippets
+	WHERE forked_from = ? AND expires > UTC_TIMESTAMP()
+	AND visibility = ? AND burn_after_reading = FALSE`
+
+	var n int
+	err := m.DB.QueryRow(stmt, id, models.VisibilityPublic).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// querySnippets runs a statement which selects snippetColumns and returns the
+// snippets it finds, in the order they were returned.
+func querySnippets(q querier, stmt string, args ...interface{}) ([]*models.Snippet, error) {
+	rows, err := q.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	// We defer rows.Close() to ensure the sql.Rows result set is
-	// always properly closed before the Latest() method returns. This defer
+	// always properly closed before querySnippets() returns. This defer
 	// statement should come *after* you check for an error from the Query()
 	// method. Otherwise, if Query() returns an error, you'll get a panic
 	// trying to close a nil result set.
@@ -84,8 +616,7 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	snippets := []*models.Snippet{}
 
 	for rows.Next() {
-		s := &models.Snippet{}
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		s, err := scanSnippet(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -102,3 +633,91 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 
 	return snippets, nil
 }
+
+// insertRevision stores a new version of a snippet, numbered one higher than
+// the latest revision it already has.
+func insertRevision(tx *sql.Tx, snippetID int, title, content string) error {
+	stmt := `INSERT INTO revisions (snippet_id, number, title, content, created)
+	SELECT ?, COALESCE(MAX(number), 0) + 1, ?, ?, UTC_TIMESTAMP()
+	FROM revisions WHERE snippet_id = ?`
+
+	_, err := tx.Exec(stmt, snippetID, title, content, snippetID)
+	return err
+}
+
+// insertFiles stores the further files of a snippet, numbering them by their
+// position so that they come back in the same order.
+func insertFiles(tx *sql.Tx, snippetID int, files []*models.File) error {
+	stmt := `INSERT INTO snippet_files (snippet_id, position, name, language, content)
+	VALUES (?, ?, ?, ?, ?)`
+
+	for i, f := range files {
+		_, err := tx.Exec(stmt, snippetID, i+1, f.Name, f.Language, f.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertTags links a snippet to its tags, creating any tags which don't
+// exist yet. The unique index on tags.name turns a second insert of the same
+// tag into an update, and LAST_INSERT_ID(id) makes that return the ID of the
+// existing row.
+func insertTags(tx *sql.Tx, snippetID int, tags []string) error {
+	for _, tag := range tags {
+		result, err := tx.Exec(`INSERT INTO tags (name) VALUES (?)
+		ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`, tag)
+		if err != nil {
+			return err
+		}
+
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO snippet_tags (snippet_id, tag_id) VALUES (?, ?)`, snippetID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteSnippets removes the snippets with the given IDs along with all of the
+// rows in other tables which belong to them. Tags themselves are shared
+// between snippets, so only the links to them are removed, and forks of the
+// snippets are kept but no longer point at them.
+func deleteSnippets(tx *sql.Tx, ids ...int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	stmts := []string{
+		`UPDATE snippets SET forked_from = NULL WHERE forked_from IN (` + placeholders + `)`,
+		`DELETE FROM revisions WHERE snippet_id IN (` + placeholders + `)`,
+		`DELETE FROM comments WHERE snippet_id IN (` + placeholders + `)`,
+		`DELETE FROM stars WHERE snippet_id IN (` + placeholders + `)`,
+		`DELETE FROM snippet_views WHERE snippet_id IN (` + placeholders + `)`,
+		`DELETE FROM snippet_tags WHERE snippet_id IN (` + placeholders + `)`,
+		`DELETE FROM snippet_files WHERE snippet_id IN (` + placeholders + `)`,
+		`DELETE FROM snippets WHERE id IN (` + placeholders + `)`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"time"
+)
+
+// StarModel keeps track of which users starred which snippets. Each star is
+// a row of its own in the stars table, whose primary key is (snippet_id,
+// user_id), and counts are always worked out from those rows rather than kept
+// in a counter column. That way a user's star is only ever counted once, and
+// the counts can't drift when many users star a snippet at the same time.
+type StarModel struct {
+	DB *sql.DB
+}
+
+// Toggle stars a snippet for a user, or takes their star away if they had
+// already starred it, and reports whether the snippet is starred now.
+func (m *StarModel) Toggle(snippetID, userID int) (bool, error) {
+	stmt := `DELETE FROM stars WHERE snippet_id = ? AND user_id = ?`
+
+	result, err := m.DB.Exec(stmt, snippetID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	// INSERT IGNORE leaves things as they are if a request which raced with
+	// this one has starred the snippet in the meantime.
+	stmt = `INSERT IGNORE INTO stars (snippet_id, user_id, created) VALUES(?, ?, UTC_TIMESTAMP())`
+
+	_, err = m.DB.Exec(stmt, snippetID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Starred reports whether the user has starred the snippet.
+func (m *StarModel) Starred(snippetID, userID int) (bool, error) {
+	stmt := `SELECT EXISTS(SELECT true FROM stars WHERE snippet_id = ? AND user_id = ?)`
+
+	var starred bool
+	err := m.DB.QueryRow(stmt, snippetID, userID).Scan(&starred)
+	return starred, err
+}
+
+// Count returns the number of users who starred the snippet.
+func (m *StarModel) Count(snippetID int) (int, error) {
+	stmt := `SELECT COUNT(*) FROM stars WHERE snippet_id = ?`
+
+	var n int
+	err := m.DB.QueryRow(stmt, snippetID).Scan(&n)
+	return n, err
+}
+
+// ForUser returns the live snippets the user starred, most recently starred
+// first. Snippets which have since been made private by their owners are
+// left out.
+func (m *StarModel) ForUser(userID int) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` ` + snippetFrom + `
+	INNER JOIN stars st ON st.snippet_id = s.id
+	WHERE st.user_id = ? AND s.expires > UTC_TIMESTAMP()
+	AND (s.visibility <> ? OR s.user_id = ?)
+	ORDER BY st.created DESC, s.id DESC`
+
+	return querySnippets(m.DB, stmt, userID, models.VisibilityPrivate, userID)
+}
+
+// MostStarred returns the live public snippets which were starred most since
+// the given time, up to limit of them. The Stars field of each holds the
+// number of stars it got in that time.
+func (m *StarModel) MostStarred(since time.Time, limit int) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + `, st.stars ` + snippetFrom + `
+	INNER JOIN (SELECT snippet_id, COUNT(*) AS stars FROM stars WHERE created >= ? GROUP BY snippet_id) st
+	ON st.snippet_id = s.id
+	WHERE s.expires > UTC_TIMESTAMP() AND s.visibility = ? AND s.burn_after_reading = FALSE
+	ORDER BY st.stars DESC, s.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, since.UTC(), models.VisibilityPublic, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+
+	for rows.Next() {
+		var stars int
+		s, err := scanSnippet(withColumns{rows, []interface{}{&stars}})
+		if err != nil {
+			return nil, err
+		}
+		s.Stars = stars
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// withColumns scans a row which has further columns after snippetColumns
+// into the given destinations.
+type withColumns struct {
+	scanner
+	dest []interface{}
+}
+
+func (w withColumns) Scan(dest ...interface{}) error {
+	return w.scanner.Scan(append(dest, w.dest...)...)
+}
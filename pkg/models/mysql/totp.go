@@ -0,0 +1,129 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+)
+
+// EnableTOTP turns on two-factor authentication for a user with the given
+// secret. Step is the period of the code the user confirmed the secret with,
+// so that code can't be used again to log in. The hashes of the user's new
+// recovery codes replace any they had before.
+func (m *UserModel) EnableTOTP(id int, secret string, step int64, recoveryHashes [][]byte) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = ?, totp_last_step = ? WHERE id = ?`
+	_, err = tx.Exec(stmt, secret, step, id)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(tx, id, recoveryHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication for a user and deletes
+// their recovery codes.
+func (m *UserModel) DisableTOTP(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?`
+	_, err = tx.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(tx, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TOTPSecret returns a user's two-factor secret, or ErrNoRecord if they
+// haven't turned on two-factor authentication.
+func (m *UserModel) TOTPSecret(id int) (string, error) {
+	stmt := `SELECT totp_secret FROM users WHERE id = ? AND totp_secret IS NOT NULL`
+
+	var secret string
+	err := m.DB.QueryRow(stmt, id).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", models.ErrNoRecord
+	}
+	return secret, err
+}
+
+// UseTOTPStep records that a user logged in with the code for the given
+// period. Codes are only accepted for periods after the last one used, so
+// ErrInvalidToken is returned if the code has been used before. The check
+// and the update are a single statement, so two logins racing with the same
+// code can't both succeed.
+func (m *UserModel) UseTOTPStep(id int, step int64) error {
+	stmt := `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+
+	result, err := m.DB.Exec(stmt, step, id, step)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidToken
+	}
+
+	return nil
+}
+
+// UseRecoveryCode uses up one of a user's recovery codes, given by its hash.
+// ErrInvalidToken is returned if the user has no such code.
+func (m *UserModel) UseRecoveryCode(id int, hash []byte) error {
+	stmt := `DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?`
+
+	result, err := m.DB.Exec(stmt, id, hash)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidToken
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, id int, hashes [][]byte) error {
+	_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO recovery_codes (user_id, code_hash) VALUES(?, ?)`
+	for _, hash := range hashes {
+		_, err = tx.Exec(stmt, id, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,42 +1,59 @@
 package mysql
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"github.com/TeslaMode1X/snippetbox/pkg/models"
 	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
 	"strings"
+	"time"
 )
 
 type UserModel struct {
 	DB *sql.DB
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
+// Insert adds a new, unverified user and returns their ID.
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	stmt := `INSERT INTO users (name, email, hashed_password, created)
-         VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	stmt := `INSERT INTO users (name, email, hashed_password, verified, created)
+         VALUES(?, ?, ?, FALSE, UTC_TIMESTAMP())`
 
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPassword))
 	if err != nil {
 		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
 			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "users_uc_email") {
-				return models.ErrDuplicateEmail
+				return 0, models.ErrDuplicateEmail
 			}
 		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
 	}
-	return err
+
+	return int(id), nil
 }
 
+// Authenticate checks a user's email address and password and returns their
+// ID. Users who haven't verified their address yet get ErrNotVerified, but
+// only once they have given the right password, so that the error doesn't
+// tell anyone else whether an address is registered.
 func (m *UserModel) Authenticate(email, password string) (int, error) {
 	var id int
 	var hashedPassword []byte
-	row := m.DB.QueryRow(`select id, hashed_password from users where email = ?`, email)
-	err := row.Scan(&id, &hashedPassword)
+	var verified bool
+	row := m.DB.QueryRow(`select id, hashed_password, verified from users where email = ?`, email)
+	err := row.Scan(&id, &hashedPassword, &verified)
 	if err == sql.ErrNoRows {
 		return 0, models.ErrInvalidCredentials
 	} else if err != nil {
@@ -52,13 +69,32 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		return 0, err
 	}
 
+	if !verified {
+		return 0, models.ErrNotVerified
+	}
+
 	return id, nil
 }
 
 func (m *UserModel) Get(id int) (*models.User, error) {
 	s := &models.User{}
-	stmt := `SELECT id, name, email, created FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Name, &s.Email, &s.Created)
+	stmt := `SELECT id, name, email, COALESCE(pending_email, ''), verified, session_version, totp_secret IS NOT NULL, created
+	FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Name, &s.Email, &s.PendingEmail, &s.Verified, &s.SessionVersion, &s.TOTPEnabled, &s.Created)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetByEmail returns the user with the given email address.
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	s := &models.User{}
+	stmt := `SELECT id, name, email, COALESCE(pending_email, ''), verified, session_version, totp_secret IS NOT NULL, created
+	FROM users WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&s.ID, &s.Name, &s.Email, &s.PendingEmail, &s.Verified, &s.SessionVersion, &s.TOTPEnabled, &s.Created)
 	if err == sql.ErrNoRows {
 		return nil, models.ErrNoRecord
 	} else if err != nil {
@@ -66,3 +102,177 @@ func (m *UserModel) Get(id int) (*models.User, error) {
 	}
 	return s, nil
 }
+
+// Verify marks a user's email address as verified.
+func (m *UserModel) Verify(id int) error {
+	return m.update(id, `UPDATE users SET verified = TRUE WHERE id = ?`)
+}
+
+// UpdateName changes a user's name.
+func (m *UserModel) UpdateName(id int, name string) error {
+	return m.update(id, `UPDATE users SET name = ? WHERE id = ?`, name)
+}
+
+// SetPendingEmail records an address a user wants to change to. Their current
+// address stays in use until ConfirmEmail is called with the new one. Like
+// Insert, it returns ErrDuplicateEmail if the address belongs to another user.
+func (m *UserModel) SetPendingEmail(id int, email string) error {
+	var taken bool
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT true FROM users WHERE email = ? AND id <> ?)`, email, id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return models.ErrDuplicateEmail
+	}
+
+	return m.update(id, `UPDATE users SET pending_email = ? WHERE id = ?`, email)
+}
+
+// ConfirmEmail makes a user's pending address their email address, as long as
+// it is still the given one. It returns ErrNoRecord if it isn't, and
+// ErrDuplicateEmail if another user took the address in the meantime. Any
+// password reset tokens which were sent to the old address are thrown away.
+func (m *UserModel) ConfirmEmail(id int, email string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET email = pending_email, pending_email = NULL
+	WHERE id = ? AND pending_email = ?`
+
+	result, err := tx.Exec(stmt, id, email)
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "users_uc_email") {
+			return models.ErrDuplicateEmail
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ChangePassword sets a new password for a user. It also bumps their session
+// version, which logs out all of their existing sessions.
+func (m *UserModel) ChangePassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	return m.update(id, `UPDATE users SET hashed_password = ?, session_version = session_version + 1 WHERE id = ?`,
+		string(hashedPassword))
+}
+
+// update runs an UPDATE statement on the user with the given ID, returning
+// ErrNoRecord if there is no such user. The statement ends with "WHERE id = ?",
+// and the ID is passed after the other arguments.
+func (m *UserModel) update(id int, stmt string, args ...interface{}) error {
+	result, err := m.DB.Exec(stmt, append(args, id)...)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MySQL reports rows which were found but already had the new values as
+	// unaffected, so only treat that as missing if the user doesn't exist.
+	if n == 0 {
+		_, err = m.Get(id)
+		return err
+	}
+
+	return nil
+}
+
+// NewResetToken creates a password reset token for a user which expires
+// after ttl. The token itself is only returned to the caller: the database
+// keeps just its SHA-256 hash, so the tokens can't be used by anyone who
+// gets to read the table.
+func (m *UserModel) NewResetToken(userID int, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	hash := sha256.Sum256([]byte(token))
+
+	stmt := `INSERT INTO password_resets (token_hash, user_id, expires)
+	VALUES(?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err = m.DB.Exec(stmt, hash[:], userID, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ResetPassword sets a new password for the user a reset token was created
+// for and returns their ID. Using a token deletes it, along with any other
+// tokens the user has, and bumps the user's session version so that their
+// existing sessions are logged out. ErrInvalidToken is returned if the token
+// doesn't exist or has expired.
+func (m *UserModel) ResetPassword(token, password string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return 0, err
+	}
+	hash := sha256.Sum256([]byte(token))
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Locking the token's row means that two requests racing to use the
+	// same token can't both succeed.
+	stmt := `SELECT user_id FROM password_resets
+	WHERE token_hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+
+	var id int
+	err = tx.QueryRow(stmt, hash[:]).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, models.ErrInvalidToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	stmt = `UPDATE users SET hashed_password = ?, session_version = session_version + 1 WHERE id = ?`
+	_, err = tx.Exec(stmt, string(hashedPassword), id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/TeslaMode1X/snippetbox/pkg/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ViewModel keeps daily view counts for snippets in the snippet_views table,
+// whose primary key is (snippet_id, day).
+type ViewModel struct {
+	DB *sql.DB
+}
+
+// Add adds views to the daily counts, given as the number of views by
+// snippet and day. All the counts are written with a single statement.
+func (m *ViewModel) Add(counts map[models.ViewDay]int) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	// Sort the keys so that concurrent batches lock the rows in the same
+	// order and can't deadlock.
+	keys := make([]models.ViewDay, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].SnippetID != keys[j].SnippetID {
+			return keys[i].SnippetID < keys[j].SnippetID
+		}
+		return keys[i].Day.Before(keys[j].Day)
+	})
+
+	rows := make([]string, len(keys))
+	args := make([]interface{}, 0, 3*len(keys))
+	for i, key := range keys {
+		rows[i] = "SELECT ?, ?, ?"
+		args = append(args, key.SnippetID, key.Day.Format("2006-01-02"), counts[key])
+	}
+	rows[0] = "SELECT ? AS snippet_id, ? AS day, ? AS views"
+
+	// Snippets can be deleted between being viewed and the batch being
+	// written. Their views are dropped here, as they would otherwise fail
+	// the foreign key on snippet_id and with it the whole batch.
+	stmt := `INSERT INTO snippet_views (snippet_id, day, views)
+	SELECT v.snippet_id, v.day, v.views FROM (` + strings.Join(rows, " UNION ALL ") + `) AS v
+	WHERE EXISTS (SELECT 1 FROM snippets s WHERE s.id = v.snippet_id)
+	ON DUPLICATE KEY UPDATE views = snippet_views.views + v.views`
+
+	_, err := m.DB.Exec(stmt, args...)
+	return err
+}
+
+// Daily returns the view counts of a snippet for each day since the given
+// time, oldest first. Days without any views are left out.
+func (m *ViewModel) Daily(snippetID int, since time.Time) ([]*models.DailyViews, error) {
+	stmt := `SELECT day, views FROM snippet_views
+	WHERE snippet_id = ? AND day >= DATE(?)
+	ORDER BY day`
+
+	rows, err := m.DB.Query(stmt, snippetID, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []*models.DailyViews{}
+
+	for rows.Next() {
+		d := &models.DailyViews{}
+		err = rows.Scan(&d.Day, &d.Views)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
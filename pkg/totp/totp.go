@@ -0,0 +1,117 @@
+// Package totp implements two-factor authentication with RFC 6238 time-based
+// one-time passwords, the six digit codes shown by authenticator apps, along
+// with the recovery codes users fall back on when they lose their device.
+package totp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"image/png"
+	"strings"
+	"time"
+)
+
+// Period is how long each code is valid for, as recommended by RFC 6238 and
+// assumed by most authenticator apps.
+const Period = 30 * time.Second
+
+// skew is the number of periods either side of the current one whose codes
+// are accepted as well, to allow for clocks which are slightly out.
+const skew = 1
+
+// Key is a newly generated secret. URL is the otpauth:// URL which
+// authenticator apps read from the QR code.
+type Key struct {
+	Secret string
+	URL    string
+}
+
+// Generate creates a new random secret for the account.
+func Generate(issuer, account string) (*Key, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: account,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{Secret: key.Secret(), URL: key.URL()}, nil
+}
+
+// QRCode renders an otpauth:// URL as a PNG QR code, size pixels across, and
+// returns it as a data: URI which can be used as the src of an <img>. The
+// image is made on the server, so the secret never leaves it.
+func QRCode(url string, size int) (string, error) {
+	key, err := otp.NewKeyFromURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	img, err := key.Image(size, size)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Validate checks a code against the secret at time t. If the code is right
+// it returns the number of the period the code belongs to, which callers
+// should record so that the same code can't be used twice.
+func Validate(code, secret string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	step := t.Unix() / int64(Period/time.Second)
+
+	for i := int64(-skew); i <= skew; i++ {
+		want, err := totp.GenerateCode(secret, time.Unix((step+i)*int64(Period/time.Second), 0))
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(want)) == 1 {
+			return step + i, true
+		}
+	}
+	return 0, false
+}
+
+// recoveryEncoding writes recovery codes in lower case letters and digits,
+// leaving out l, o, 0 and 1, which are easy to mix up.
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// RecoveryCodes returns n new random recovery codes, such as "k7dq-m2xa".
+func RecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		s := recoveryEncoding.EncodeToString(b)
+		codes[i] = s[:4] + "-" + s[4:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hash which is stored in place of a recovery
+// code. Case, spaces and dashes are ignored, so that codes can be typed in
+// however the user likes. The codes are random, so a fast hash is enough.
+func HashRecoveryCode(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
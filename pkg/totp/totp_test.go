@@ -0,0 +1,98 @@
+package totp
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret from the test vectors in RFC 6238,
+// "12345678901234567890", encoded in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidate(t *testing.T) {
+	// The RFC gives 8 digit codes; ours are their last 6 digits.
+	tests := []struct {
+		name     string
+		code     string
+		time     time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"RFC vector at 59s", "287082", time.Unix(59, 0), 1, true},
+		{"RFC vector at 1111111109s", "081804", time.Unix(1111111109, 0), 37037036, true},
+		{"Previous period", "287082", time.Unix(89, 0), 1, true},
+		{"Next period", "287082", time.Unix(10, 0), 1, true},
+		{"Too old", "287082", time.Unix(120, 0), 0, false},
+		{"Wrong code", "123456", time.Unix(59, 0), 0, false},
+		{"Spaces", " 287082 ", time.Unix(59, 0), 1, true},
+		{"Empty", "", time.Unix(59, 0), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.code, rfcSecret, tt.time)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("want %d, %t; got %d, %t", tt.wantStep, tt.wantOK, step, ok)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	key, err := Generate("Snippetbox", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key.URL, "otpauth://totp/Snippetbox:alice@example.com?") {
+		t.Errorf("unexpected URL %q", key.URL)
+	}
+	if !strings.Contains(key.URL, "secret="+key.Secret) {
+		t.Errorf("want the URL %q to contain the secret", key.URL)
+	}
+
+	img, err := QRCode(key.URL, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(img, "data:image/png;base64,") {
+		t.Errorf("want a PNG data URI; got %.40q", img)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := RecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("want 10 codes; got %d", len(codes))
+	}
+
+	rx := regexp.MustCompile(`^[a-km-np-z2-9]{4}-[a-km-np-z2-9]{4}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !rx.MatchString(code) {
+			t.Errorf("unexpected code %q", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("k7dq-m2xa")
+	for _, code := range []string{"K7DQ-M2XA", "k7dqm2xa", " k7dq m2xa "} {
+		if !bytes.Equal(HashRecoveryCode(code), want) {
+			t.Errorf("want %q to hash like k7dq-m2xa", code)
+		}
+	}
+	if bytes.Equal(HashRecoveryCode("k7dq-m2xb"), want) {
+		t.Errorf("want different codes to hash differently")
+	}
+}
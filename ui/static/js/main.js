@@ -5,4 +5,60 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+// Forms with a data-preview attribute post their content to that URL and
+// show the rendered Markdown which comes back.
+var previewForms = document.querySelectorAll("form[data-preview]");
+for (var i = 0; i < previewForms.length; i++) {
+	(function (form) {
+		var button = form.querySelector("button.preview");
+		var output = form.querySelector("div.preview");
+		button.addEventListener("click", function () {
+			fetch(form.getAttribute("data-preview"), {
+				method: "POST",
+				body: new URLSearchParams(new FormData(form)),
+				credentials: "same-origin"
+			}).then(function (response) {
+				if (!response.ok) {
+					throw new Error(response.statusText);
+				}
+				return response.text();
+			}).then(function (html) {
+				output.innerHTML = html;
+				output.hidden = false;
+			}).catch(function (err) {
+				output.textContent = "Preview failed: " + err.message;
+				output.hidden = false;
+			});
+		});
+	})(previewForms[i]);
+}
+
+// The further files of a snippet are added by copying the file template, and
+// removed by taking their fieldset out of the form.
+var fileLists = document.querySelectorAll("div.files");
+for (var i = 0; i < fileLists.length; i++) {
+	(function (list) {
+		var template = list.querySelector("template.file");
+		var add = list.querySelector("button.add-file");
+		add.addEventListener("click", function () {
+			list.insertBefore(template.content.cloneNode(true), add);
+		});
+		list.addEventListener("click", function (event) {
+			if (event.target.classList.contains("remove-file")) {
+				list.removeChild(event.target.closest("fieldset.file"));
+			}
+		});
+	})(fileLists[i]);
+}
+
+// Clicking a line number of a snippet also picks that line for a new comment.
+var lineInput = document.querySelector("form.comment input[name=line]");
+if (lineInput) {
+	var lineLinks = document.querySelectorAll(".snippet .code a[href^='#L']");
+	for (var i = 0; i < lineLinks.length; i++) {
+		lineLinks[i].addEventListener("click", function (event) {
+			lineInput.value = event.currentTarget.getAttribute("href").slice(2);
+		});
+	}
+}